@@ -0,0 +1,361 @@
+package fakedynamo
+
+import (
+	"sort"
+	"strconv"
+	"unicode/utf8"
+)
+
+// resolve devuelve el valor de p en it, o nil si no existe.
+func resolve(it item, p path) *value {
+	v := it[p[0].name]
+	for _, e := range p[1:] {
+		switch {
+		case v == nil:
+			return nil
+		case e.isIdx:
+			if v.t != "L" || e.index >= len(v.l) {
+				return nil
+			}
+			v = v.l[e.index]
+		default:
+			if v.t != "M" {
+				return nil
+			}
+			v = v.m[e.name]
+		}
+	}
+	return v
+}
+
+func (o operand) eval(it item) *value {
+	if o.val != nil {
+		return o.val
+	}
+	v := resolve(it, o.path)
+	if !o.size || v == nil {
+		return v
+	}
+	var n int
+	switch v.t {
+	case "S":
+		n = utf8.RuneCountInString(v.s)
+	case "B":
+		n = len(v.s)
+	case "SS", "NS", "BS":
+		n = len(v.set)
+	case "L":
+		n = len(v.l)
+	case "M":
+		n = len(v.m)
+	default:
+		return nil
+	}
+	return &value{t: "N", s: strconv.Itoa(n)}
+}
+
+// eval evalua la condicion sobre it, que es nil si el item no existe. Una
+// comparacion con un atributo que no existe, o de tipos que no se pueden
+// ordenar, es falsa; <> entre valores distintos o con uno que no existe es
+// cierta.
+func (n *condNode) eval(it item) bool {
+	switch n.op {
+	case "AND":
+		return n.kids[0].eval(it) && n.kids[1].eval(it)
+	case "OR":
+		return n.kids[0].eval(it) || n.kids[1].eval(it)
+	case "NOT":
+		return !n.kids[0].eval(it)
+	case "cmp":
+		a, b := n.args[0].eval(it), n.args[1].eval(it)
+		switch n.cmp {
+		case "=":
+			return equal(a, b)
+		case "<>":
+			return !equal(a, b)
+		}
+		c, ok := compare(a, b)
+		if !ok {
+			return false
+		}
+		switch n.cmp {
+		case "<":
+			return c < 0
+		case "<=":
+			return c <= 0
+		case ">":
+			return c > 0
+		}
+		return c >= 0
+	case "BETWEEN":
+		x := n.args[0].eval(it)
+		lo, ok1 := compare(x, n.args[1].eval(it))
+		hi, ok2 := compare(x, n.args[2].eval(it))
+		return ok1 && ok2 && lo >= 0 && hi <= 0
+	case "IN":
+		x := n.args[0].eval(it)
+		for _, o := range n.args[1:] {
+			if equal(x, o.eval(it)) {
+				return true
+			}
+		}
+		return false
+	}
+	v := n.args[0].eval(it)
+	switch n.fn {
+	case "attribute_exists":
+		return v != nil
+	case "attribute_not_exists":
+		return v == nil
+	case "attribute_type":
+		t := n.args[1].eval(it)
+		return v != nil && t != nil && t.t == "S" && v.t == t.s
+	case "begins_with":
+		return beginsWith(v, n.args[1].eval(it))
+	}
+	return contains(v, n.args[1].eval(it))
+}
+
+// uses indica si la condicion usa el atributo attr.
+func (n *condNode) uses(attr string) bool {
+	for _, k := range n.kids {
+		if k.uses(attr) {
+			return true
+		}
+	}
+	for _, o := range n.args {
+		if o.path != nil && o.path[0].name == attr {
+			return true
+		}
+	}
+	return false
+}
+
+// eval calcula el lado derecho de un SET con el item de antes del update.
+func (v *setValue) eval(it item) (*value, error) {
+	a, err := v.terms[0].eval(it)
+	if err != nil || v.op == "" {
+		return a, err
+	}
+	b, err := v.terms[1].eval(it)
+	if err != nil {
+		return nil, err
+	}
+	if a.t != "N" || b.t != "N" {
+		return nil, validationf("An operand in the update expression has an incorrect data type")
+	}
+	x, y := number(a), number(b)
+	if v.op == "+" {
+		x.Add(x, y)
+	} else {
+		x.Sub(x, y)
+	}
+	return &value{t: "N", s: formatNumber(x)}, nil
+}
+
+func (t *setTerm) eval(it item) (*value, error) {
+	switch t.fn {
+	case "if_not_exists":
+		if v := resolve(it, t.args[0].path); v != nil {
+			return v, nil
+		}
+		return t.args[1].eval(it)
+	case "list_append":
+		a, err := t.args[0].eval(it)
+		if err != nil {
+			return nil, err
+		}
+		b, err := t.args[1].eval(it)
+		if err != nil {
+			return nil, err
+		}
+		if a.t != "L" || b.t != "L" {
+			return nil, validationf("An operand in the update expression has an incorrect data type")
+		}
+		return &value{t: "L", l: append(append([]*value{}, a.l...), b.l...)}, nil
+	}
+	v := t.operand.eval(it)
+	if v == nil {
+		return nil, validationf("The provided expression refers to an attribute that does not exist in the item")
+	}
+	return v, nil
+}
+
+// apply aplica u sobre it. Como en DynamoDB, los valores de SET se calculan
+// con el item de antes del update.
+func (u *update) apply(it item) error {
+	vals := make([]*value, len(u.set))
+	for i, a := range u.set {
+		v, err := a.val.eval(it)
+		if err != nil {
+			return err
+		}
+		vals[i] = v.clone()
+	}
+	for i, a := range u.set {
+		if err := assign(it, a.path, vals[i]); err != nil {
+			return err
+		}
+	}
+	// Los indices de REMOVE son los de antes: primero los mas altos
+	remove := append([]path{}, u.remove...)
+	sort.SliceStable(remove, func(i, j int) bool {
+		a, b := remove[i][len(remove[i])-1], remove[j][len(remove[j])-1]
+		return a.isIdx && b.isIdx && a.index > b.index
+	})
+	for _, p := range remove {
+		removePath(it, p)
+	}
+	for _, a := range u.add {
+		old := resolve(it, a.path)
+		var v *value
+		switch {
+		case a.val.t != "N" && !isSet(a.val):
+			return validationf("Invalid UpdateExpression: Incorrect operand type for operator or function; operator: ADD, operand type: %s", a.val.t)
+		case old == nil:
+			v = a.val.clone()
+		case old.t != a.val.t:
+			return validationf("An operand in the update expression has an incorrect data type")
+		case old.t == "N":
+			x := number(old)
+			v = &value{t: "N", s: formatNumber(x.Add(x, number(a.val)))}
+		default:
+			v = old.clone()
+			for _, s := range a.val.set {
+				if !containsString(v.set, s) {
+					v.set = append(v.set, s)
+				}
+			}
+		}
+		if err := assign(it, a.path, v); err != nil {
+			return err
+		}
+	}
+	for _, a := range u.del {
+		old := resolve(it, a.path)
+		switch {
+		case !isSet(a.val):
+			return validationf("Invalid UpdateExpression: Incorrect operand type for operator or function; operator: DELETE, operand type: %s", a.val.t)
+		case old == nil:
+			continue
+		case old.t != a.val.t:
+			return validationf("An operand in the update expression has an incorrect data type")
+		}
+		v := &value{t: old.t}
+		for _, s := range old.set {
+			if !containsString(a.val.set, s) {
+				v.set = append(v.set, s)
+			}
+		}
+		if len(v.set) == 0 {
+			removePath(it, a.path)
+			continue
+		}
+		if err := assign(it, a.path, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isSet(v *value) bool {
+	return v.t == "SS" || v.t == "NS" || v.t == "BS"
+}
+
+// assign pone v en p. El padre de p tiene que existir; un indice mas alla
+// del final de una lista anade al final.
+func assign(it item, p path, v *value) error {
+	if len(p) == 1 {
+		it[p[0].name] = v
+		return nil
+	}
+	parent := resolve(it, p[:len(p)-1])
+	last := p[len(p)-1]
+	switch {
+	case parent != nil && last.isIdx && parent.t == "L":
+		if last.index >= len(parent.l) {
+			parent.l = append(parent.l, v)
+		} else {
+			parent.l[last.index] = v
+		}
+		return nil
+	case parent != nil && !last.isIdx && parent.t == "M":
+		if parent.m == nil {
+			parent.m = map[string]*value{}
+		}
+		parent.m[last.name] = v
+		return nil
+	}
+	return validationf("The document path provided in the update expression is invalid for update")
+}
+
+func removePath(it item, p path) {
+	if len(p) == 1 {
+		delete(it, p[0].name)
+		return
+	}
+	parent := resolve(it, p[:len(p)-1])
+	last := p[len(p)-1]
+	switch {
+	case parent == nil:
+	case last.isIdx && parent.t == "L" && last.index < len(parent.l):
+		parent.l = append(parent.l[:last.index], parent.l[last.index+1:]...)
+	case !last.isIdx && parent.t == "M":
+		delete(parent.m, last.name)
+	}
+}
+
+// project devuelve los atributos de it en paths. Los elementos de lista
+// proyectados quedan, en orden, en una lista mas corta.
+func project(it item, paths []path) item {
+	if it == nil {
+		return nil
+	}
+	out := item{}
+	for _, p := range paths {
+		v := resolve(it, p)
+		if v == nil {
+			continue
+		}
+		if len(p) == 1 {
+			out[p[0].name] = v
+			continue
+		}
+		src := it[p[0].name]
+		dst := out[p[0].name]
+		if dst == nil {
+			dst = &value{t: src.t}
+			out[p[0].name] = dst
+		}
+		for i, e := range p[1:] {
+			if e.isIdx {
+				src = src.l[e.index]
+			} else {
+				src = src.m[e.name]
+			}
+			if i == len(p)-2 {
+				putChild(dst, e, src)
+				break
+			}
+			dst = putChild(dst, e, &value{t: src.t})
+		}
+	}
+	return out
+}
+
+// putChild pone child en el elemento e de dst, o devuelve el que ya habia
+// de una proyeccion anterior.
+func putChild(dst *value, e pathElem, child *value) *value {
+	if !e.isIdx {
+		if dst.m == nil {
+			dst.m = map[string]*value{}
+		}
+		if old, ok := dst.m[e.name]; ok && old.t == child.t && (old.t == "M" || old.t == "L") {
+			return old
+		}
+		dst.m[e.name] = child
+		return child
+	}
+	dst.l = append(dst.l, child)
+	return child
+}
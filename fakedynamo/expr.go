@@ -0,0 +1,653 @@
+package fakedynamo
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Expresiones de DynamoDB: condiciones (ConditionExpression, FilterExpression
+// y KeyConditionExpression), updates y proyecciones. No se comprueban las
+// palabras reservadas: un nombre sin # se toma siempre como atributo.
+
+const (
+	tokEOF    = iota
+	tokIdent  // atributo, funcion o palabra clave
+	tokName   // #nombre
+	tokValue  // :valor
+	tokNumber // indice de lista
+	tokSym    // ( ) , . [ ] = <> < <= > >= + -
+)
+
+type token struct {
+	kind int
+	text string
+}
+
+func tokenize(s string) ([]token, error) {
+	var toks []token
+	isWord := func(c byte) bool {
+		return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+	}
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+			continue
+		case c == '#' || c == ':' || isWord(c):
+			j := i + 1
+			for j < len(s) && isWord(s[j]) {
+				j++
+			}
+			kind := tokIdent
+			switch {
+			case c == '#':
+				kind = tokName
+			case c == ':':
+				kind = tokValue
+			case c >= '0' && c <= '9':
+				kind = tokNumber
+			}
+			if j == i+1 && kind != tokIdent && kind != tokNumber {
+				return nil, fmt.Errorf("Syntax error; token: %q", s[i:j])
+			}
+			toks = append(toks, token{kind, s[i:j]})
+			i = j
+			continue
+		}
+		sym := string(c)
+		if i+1 < len(s) {
+			if two := s[i : i+2]; two == "<=" || two == ">=" || two == "<>" {
+				sym = two
+			}
+		}
+		if !strings.Contains("(),.[]=<>+-", string(c)) {
+			return nil, fmt.Errorf("Invalid character encountered; token: %q", sym)
+		}
+		toks = append(toks, token{tokSym, sym})
+		i += len(sym)
+	}
+	return append(toks, token{kind: tokEOF}), nil
+}
+
+// pathElem es un elemento de un document path: un atributo o un indice.
+type pathElem struct {
+	name  string
+	index int
+	isIdx bool
+}
+
+type path []pathElem
+
+func (p path) String() string {
+	var b strings.Builder
+	for i, e := range p {
+		switch {
+		case e.isIdx:
+			fmt.Fprintf(&b, "[%d]", e.index)
+		case i > 0:
+			b.WriteString("." + e.name)
+		default:
+			b.WriteString(e.name)
+		}
+	}
+	return b.String()
+}
+
+// overlaps indica si un path es prefijo del otro.
+func (p path) overlaps(q path) bool {
+	if len(q) < len(p) {
+		p, q = q, p
+	}
+	for i := range p {
+		if p[i] != q[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// operand es un operando de una condicion: un path, un valor o size(path).
+type operand struct {
+	path path
+	val  *value
+	size bool
+}
+
+// condNode es un nodo de una condicion. op es AND, OR, NOT, cmp, BETWEEN, IN
+// o func.
+type condNode struct {
+	op   string
+	kids []*condNode
+	cmp  string // =, <>, <, <=, >, >= en op cmp
+	fn   string // attribute_exists, begins_with, ... en op func
+	args []operand
+}
+
+// setTerm es un termino del lado derecho de un SET: un operando o una
+// llamada a if_not_exists o list_append.
+type setTerm struct {
+	operand
+	fn   string
+	args []*setTerm
+}
+
+// setValue es el lado derecho de un SET: un termino o una suma o resta de
+// dos.
+type setValue struct {
+	op    string // "", "+" o "-"
+	terms []*setTerm
+}
+
+type setAction struct {
+	path path
+	val  *setValue
+}
+
+// addAction es una accion de ADD o DELETE.
+type addAction struct {
+	path path
+	val  *value
+}
+
+type update struct {
+	set    []setAction
+	remove []path
+	add    []addAction
+	del    []addAction
+}
+
+// paths son los document paths que modifica u.
+func (u *update) paths() []path {
+	var ps []path
+	for _, a := range u.set {
+		ps = append(ps, a.path)
+	}
+	ps = append(ps, u.remove...)
+	for _, a := range u.add {
+		ps = append(ps, a.path)
+	}
+	for _, a := range u.del {
+		ps = append(ps, a.path)
+	}
+	return ps
+}
+
+// exprs son los ExpressionAttributeNames/Values de una peticion y los que
+// han usado sus expresiones.
+type exprs struct {
+	names      map[string]string
+	values     item
+	usedNames  map[string]bool
+	usedValues map[string]bool
+}
+
+func newExprs(names map[string]string, values item) *exprs {
+	return &exprs{names: names, values: values, usedNames: map[string]bool{}, usedValues: map[string]bool{}}
+}
+
+// unused falla, como DynamoDB, si sobran nombres o valores.
+func (x *exprs) unused() error {
+	for _, kind := range []struct {
+		param string
+		used  map[string]bool
+		keys  []string
+	}{
+		{"ExpressionAttributeNames", x.usedNames, keysOf(x.names)},
+		{"ExpressionAttributeValues", x.usedValues, keysOf(x.values)},
+	} {
+		extra := map[string]bool{}
+		for _, k := range kind.keys {
+			if !kind.used[k] {
+				extra[k] = true
+			}
+		}
+		if len(extra) > 0 {
+			return validationf("Value provided in %s unused in expressions: keys: {%s}", kind.param, strings.Join(keysOf(extra), ", "))
+		}
+	}
+	return nil
+}
+
+// keysOf devuelve las claves de m ordenadas.
+func keysOf[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type parser struct {
+	x    *exprs
+	kind string // ConditionExpression, UpdateExpression, ...
+	toks []token
+	pos  int
+}
+
+func (x *exprs) parser(kind, s string) (*parser, error) {
+	toks, err := tokenize(s)
+	if err != nil {
+		return nil, validationf("Invalid %s: %v", kind, err)
+	}
+	if len(toks) == 1 {
+		return nil, validationf("Invalid %s: The expression can not be empty;", kind)
+	}
+	return &parser{x: x, kind: kind, toks: toks}, nil
+}
+
+func (p *parser) peek() token {
+	return p.toks[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.toks[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// isSym indica si el siguiente token es el simbolo s.
+func (p *parser) isSym(s string) bool {
+	t := p.peek()
+	return t.kind == tokSym && t.text == s
+}
+
+// isKeyword indica si el siguiente token es la palabra clave kw.
+func (p *parser) isKeyword(kw string) bool {
+	t := p.peek()
+	return t.kind == tokIdent && strings.EqualFold(t.text, kw)
+}
+
+// isCall indica si sigue una llamada a la funcion fn.
+func (p *parser) isCall(fn string) bool {
+	if p.pos+1 >= len(p.toks) {
+		return false
+	}
+	t, n := p.peek(), p.toks[p.pos+1]
+	return t.kind == tokIdent && t.text == fn && n.kind == tokSym && n.text == "("
+}
+
+func (p *parser) syntaxError() error {
+	t := p.peek()
+	if t.kind == tokEOF {
+		return validationf("Invalid %s: Syntax error; token: \"<EOF>\"", p.kind)
+	}
+	return validationf("Invalid %s: Syntax error; token: %q", p.kind, t.text)
+}
+
+func (p *parser) expect(sym string) error {
+	if !p.isSym(sym) {
+		return p.syntaxError()
+	}
+	p.next()
+	return nil
+}
+
+func (p *parser) end() error {
+	if p.peek().kind != tokEOF {
+		return p.syntaxError()
+	}
+	return nil
+}
+
+// name resuelve un elemento de nombre, con o sin #.
+func (p *parser) name() (string, error) {
+	switch t := p.peek(); t.kind {
+	case tokIdent:
+		p.next()
+		return t.text, nil
+	case tokName:
+		name, ok := p.x.names[t.text]
+		if !ok {
+			return "", validationf("Invalid %s: An expression attribute name used in the document path is not defined; attribute name: %s", p.kind, t.text)
+		}
+		p.next()
+		p.x.usedNames[t.text] = true
+		return name, nil
+	}
+	return "", p.syntaxError()
+}
+
+func (p *parser) path() (path, error) {
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	pa := path{{name: name}}
+	for {
+		switch {
+		case p.isSym("."):
+			p.next()
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			pa = append(pa, pathElem{name: name})
+		case p.isSym("["):
+			p.next()
+			idx, err := strconv.Atoi(p.peek().text)
+			if p.peek().kind != tokNumber || err != nil {
+				return nil, p.syntaxError()
+			}
+			p.next()
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			pa = append(pa, pathElem{index: idx, isIdx: true})
+		default:
+			return pa, nil
+		}
+	}
+}
+
+// valueRef resuelve un :valor.
+func (p *parser) valueRef() (*value, error) {
+	t := p.peek()
+	if t.kind != tokValue {
+		return nil, p.syntaxError()
+	}
+	v, ok := p.x.values[t.text]
+	if !ok {
+		return nil, validationf("Invalid %s: An expression attribute value used in expression is not defined; attribute value: %s", p.kind, t.text)
+	}
+	p.next()
+	p.x.usedValues[t.text] = true
+	return v, nil
+}
+
+func (p *parser) operand() (operand, error) {
+	switch {
+	case p.peek().kind == tokValue:
+		v, err := p.valueRef()
+		return operand{val: v}, err
+	case p.isCall("size"):
+		p.pos += 2
+		pa, err := p.path()
+		if err != nil {
+			return operand{}, err
+		}
+		return operand{path: pa, size: true}, p.expect(")")
+	}
+	pa, err := p.path()
+	return operand{path: pa}, err
+}
+
+// condition analiza una condicion entera.
+func (x *exprs) condition(kind, s string) (*condNode, error) {
+	p, err := x.parser(kind, s)
+	if err != nil {
+		return nil, err
+	}
+	n, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	return n, p.end()
+}
+
+func (p *parser) or() (*condNode, error) {
+	return p.binary("OR", p.and)
+}
+
+func (p *parser) and() (*condNode, error) {
+	return p.binary("AND", p.not)
+}
+
+func (p *parser) binary(op string, sub func() (*condNode, error)) (*condNode, error) {
+	left, err := sub()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword(op) {
+		p.next()
+		right, err := sub()
+		if err != nil {
+			return nil, err
+		}
+		left = &condNode{op: op, kids: []*condNode{left, right}}
+	}
+	return left, nil
+}
+
+func (p *parser) not() (*condNode, error) {
+	if !p.isKeyword("NOT") {
+		return p.primary()
+	}
+	p.next()
+	n, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	return &condNode{op: "NOT", kids: []*condNode{n}}, nil
+}
+
+// condFuncs son las funciones booleanas y su numero de argumentos.
+var condFuncs = map[string]int{
+	"attribute_exists":     1,
+	"attribute_not_exists": 1,
+	"attribute_type":       2,
+	"begins_with":          2,
+	"contains":             2,
+}
+
+func (p *parser) primary() (*condNode, error) {
+	if p.isSym("(") {
+		p.next()
+		n, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		return n, p.expect(")")
+	}
+	if t := p.peek(); t.kind == tokIdent {
+		if nargs, ok := condFuncs[t.text]; ok && p.isCall(t.text) {
+			return p.call(t.text, nargs)
+		}
+	}
+	a, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	switch t := p.peek(); {
+	case t.kind == tokSym && strings.Contains(" = <> < <= > >= ", " "+t.text+" "):
+		p.next()
+		b, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		return &condNode{op: "cmp", cmp: t.text, args: []operand{a, b}}, nil
+	case p.isKeyword("BETWEEN"):
+		p.next()
+		lo, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		if !p.isKeyword("AND") {
+			return nil, p.syntaxError()
+		}
+		p.next()
+		hi, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		return &condNode{op: "BETWEEN", args: []operand{a, lo, hi}}, nil
+	case p.isKeyword("IN"):
+		p.next()
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		n := &condNode{op: "IN", args: []operand{a}}
+		for {
+			b, err := p.operand()
+			if err != nil {
+				return nil, err
+			}
+			n.args = append(n.args, b)
+			if !p.isSym(",") {
+				break
+			}
+			p.next()
+		}
+		return n, p.expect(")")
+	}
+	return nil, p.syntaxError()
+}
+
+func (p *parser) call(fn string, nargs int) (*condNode, error) {
+	p.pos += 2
+	n := &condNode{op: "func", fn: fn}
+	for i := 0; i < nargs; i++ {
+		if i > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		a, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		n.args = append(n.args, a)
+	}
+	if n.args[0].path == nil || n.args[0].size {
+		return nil, validationf("Invalid %s: Incorrect operand type for operator or function; operator or function: %s", p.kind, fn)
+	}
+	return n, p.expect(")")
+}
+
+// update analiza una UpdateExpression.
+func (x *exprs) update(s string) (*update, error) {
+	p, err := x.parser("UpdateExpression", s)
+	if err != nil {
+		return nil, err
+	}
+	u := &update{}
+	seen := map[string]bool{}
+	for p.peek().kind != tokEOF {
+		t := p.peek()
+		clause := strings.ToUpper(t.text)
+		if t.kind != tokIdent || (clause != "SET" && clause != "REMOVE" && clause != "ADD" && clause != "DELETE") {
+			return nil, p.syntaxError()
+		}
+		p.next()
+		if seen[clause] {
+			return nil, validationf("Invalid UpdateExpression: The %q section can only be used once in an update expression;", clause)
+		}
+		seen[clause] = true
+		for {
+			pa, err := p.path()
+			if err != nil {
+				return nil, err
+			}
+			switch clause {
+			case "SET":
+				if err := p.expect("="); err != nil {
+					return nil, err
+				}
+				v, err := p.setValue()
+				if err != nil {
+					return nil, err
+				}
+				u.set = append(u.set, setAction{pa, v})
+			case "REMOVE":
+				u.remove = append(u.remove, pa)
+			default:
+				v, err := p.valueRef()
+				if err != nil {
+					return nil, err
+				}
+				if clause == "ADD" {
+					u.add = append(u.add, addAction{pa, v})
+				} else {
+					u.del = append(u.del, addAction{pa, v})
+				}
+			}
+			if !p.isSym(",") {
+				break
+			}
+			p.next()
+		}
+	}
+	ps := u.paths()
+	for i := range ps {
+		for j := i + 1; j < len(ps); j++ {
+			if ps[i].overlaps(ps[j]) {
+				return nil, validationf("Invalid UpdateExpression: Two document paths overlap with each other; must remove or rewrite one of these paths; path one: [%s], path two: [%s]", ps[i], ps[j])
+			}
+		}
+	}
+	return u, nil
+}
+
+func (p *parser) setValue() (*setValue, error) {
+	t, err := p.setTerm()
+	if err != nil {
+		return nil, err
+	}
+	v := &setValue{terms: []*setTerm{t}}
+	if p.isSym("+") || p.isSym("-") {
+		v.op = p.next().text
+		t, err := p.setTerm()
+		if err != nil {
+			return nil, err
+		}
+		v.terms = append(v.terms, t)
+	}
+	return v, nil
+}
+
+func (p *parser) setTerm() (*setTerm, error) {
+	for _, fn := range []string{"if_not_exists", "list_append"} {
+		if !p.isCall(fn) {
+			continue
+		}
+		p.pos += 2
+		t := &setTerm{fn: fn}
+		for i := 0; i < 2; i++ {
+			if i > 0 {
+				if err := p.expect(","); err != nil {
+					return nil, err
+				}
+			}
+			a, err := p.setTerm()
+			if err != nil {
+				return nil, err
+			}
+			t.args = append(t.args, a)
+		}
+		if fn == "if_not_exists" && (t.args[0].fn != "" || t.args[0].path == nil) {
+			return nil, validationf("Invalid UpdateExpression: Operator or function requires a document path; operator or function: if_not_exists")
+		}
+		return t, p.expect(")")
+	}
+	if p.isCall("size") {
+		return nil, validationf("Invalid UpdateExpression: The function is not allowed in an update expression; function: size")
+	}
+	o, err := p.operand()
+	return &setTerm{operand: o}, err
+}
+
+// projection analiza una ProjectionExpression.
+func (x *exprs) projection(s string) ([]path, error) {
+	p, err := x.parser("ProjectionExpression", s)
+	if err != nil {
+		return nil, err
+	}
+	var ps []path
+	for {
+		pa, err := p.path()
+		if err != nil {
+			return nil, err
+		}
+		ps = append(ps, pa)
+		if !p.isSym(",") {
+			break
+		}
+		p.next()
+	}
+	return ps, p.end()
+}
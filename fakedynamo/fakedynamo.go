@@ -0,0 +1,182 @@
+// Package fakedynamo es un DynamoDB en memoria para tests. Sirve el protocolo
+// JSON de DynamoDB (X-Amz-Target DynamoDB_20120810.*) con httptest, asi que
+// el codigo bajo prueba usa el *dynamodb.Client de siempre, solo que
+// configurado con Client o Config en lugar de DynamoDB Local.
+//
+// Implementa las operaciones que usa este repositorio: CreateTable,
+// UpdateTable (GSIs), DeleteTable, DescribeTable, UpdateTimeToLive,
+// DescribeTimeToLive, PutItem, UpdateItem, DeleteItem, GetItem, Query (tabla e
+// indices) y TransactWriteItems. Evalua las expresiones de condicion, update
+// y proyeccion con sus nombres y valores, y devuelve los errores de DynamoDB
+// (ConditionalCheckFailedException con Item, TransactionCanceledException
+// con CancellationReasons, ...). Las tablas estan activas nada mas crearlas
+// y todas las lecturas son consistentes. El TTL se guarda pero no borra
+// items.
+package fakedynamo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+const (
+	region       = "us-east-1"
+	targetPrefix = "DynamoDB_20120810."
+	errorPrefix  = "com.amazonaws.dynamodb.v20120810#"
+)
+
+// Server es un DynamoDB en memoria. Las peticiones se atienden de una en una.
+type Server struct {
+	srv *httptest.Server
+
+	mu     sync.Mutex
+	tables map[string]*table
+}
+
+// New arranca un Server vacio. Hay que cerrarlo con Close.
+func New() *Server {
+	s := &Server{tables: map[string]*table{}}
+	s.srv = httptest.NewServer(s)
+	return s
+}
+
+// URL es el endpoint del servidor.
+func (s *Server) URL() string {
+	return s.srv.URL
+}
+
+// Close para el servidor.
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// Config es una configuracion de AWS que apunta al servidor, sin
+// credenciales.
+func (s *Server) Config() aws.Config {
+	return aws.Config{
+		Region:       region,
+		BaseEndpoint: aws.String(s.srv.URL),
+		Credentials:  aws.AnonymousCredentials{},
+		HTTPClient:   s.srv.Client(),
+	}
+}
+
+// Client es un cliente de DynamoDB que usa el servidor.
+func (s *Server) Client() *dynamodb.Client {
+	return dynamodb.NewFromConfig(s.Config())
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	op := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), targetPrefix)
+	// La respuesta se serializa con el mutex: comparte valores con las tablas
+	s.mu.Lock()
+	out, err := s.do(op, body)
+	var data []byte
+	if err == nil {
+		data, err = json.Marshal(out)
+	}
+	s.mu.Unlock()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Write(data)
+}
+
+func (s *Server) do(op string, body []byte) (interface{}, error) {
+	switch op {
+	case "CreateTable":
+		return s.createTable(body)
+	case "UpdateTable":
+		return s.updateTable(body)
+	case "DeleteTable":
+		return s.deleteTable(body)
+	case "DescribeTable":
+		return s.describeTable(body)
+	case "UpdateTimeToLive":
+		return s.updateTimeToLive(body)
+	case "DescribeTimeToLive":
+		return s.describeTimeToLive(body)
+	case "PutItem":
+		return s.putItem(body)
+	case "UpdateItem":
+		return s.updateItem(body)
+	case "DeleteItem":
+		return s.deleteItem(body)
+	case "GetItem":
+		return s.getItem(body)
+	case "Query":
+		return s.query(body)
+	case "TransactWriteItems":
+		return s.transactWriteItems(body)
+	}
+	return nil, &apiError{typ: "UnknownOperationException", msg: "fakedynamo: operation " + op + " is not supported"}
+}
+
+// decode lee el cuerpo de una peticion en in.
+func decode(body []byte, in interface{}) error {
+	if err := json.Unmarshal(body, in); err != nil {
+		return validationf("%v", err)
+	}
+	return nil
+}
+
+// apiError es un error de DynamoDB, con el __type y los campos que el SDK
+// lee de cada excepcion.
+type apiError struct {
+	typ     string
+	msg     string
+	item    item                 // ConditionalCheckFailedException
+	reasons []cancellationReason // TransactionCanceledException
+}
+
+type cancellationReason struct {
+	Code    string
+	Message string `json:",omitempty"`
+	Item    item   `json:",omitempty"`
+}
+
+func (e *apiError) Error() string {
+	return e.typ + ": " + e.msg
+}
+
+func validationf(format string, args ...interface{}) error {
+	return &apiError{typ: "ValidationException", msg: fmt.Sprintf(format, args...)}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusBadRequest
+	var ae *apiError
+	if !errors.As(err, &ae) {
+		status = http.StatusInternalServerError
+		ae = &apiError{typ: "InternalServerError", msg: err.Error()}
+	}
+	body := map[string]interface{}{
+		"__type":  errorPrefix + ae.typ,
+		"message": ae.msg,
+	}
+	if ae.item != nil {
+		body["Item"] = ae.item
+	}
+	if ae.reasons != nil {
+		body["CancellationReasons"] = ae.reasons
+	}
+	data, _ := json.Marshal(body)
+	w.WriteHeader(status)
+	w.Write(data)
+}
@@ -0,0 +1,477 @@
+package fakedynamo
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go"
+)
+
+// newServer arranca un Server con la tabla Jira (PK S, SK N), como la de
+// sequentialIds.
+func newServer(t *testing.T) (*Server, *dynamodb.Client) {
+	t.Helper()
+	s := New()
+	t.Cleanup(s.Close)
+	svc := s.Client()
+	_, err := svc.CreateTable(context.Background(), &dynamodb.CreateTableInput{
+		TableName:   aws.String("Jira"),
+		BillingMode: types.BillingModePayPerRequest,
+		KeySchema: []types.KeySchemaElement{
+			{AttributeName: aws.String("PK"), KeyType: types.KeyTypeHash},
+			{AttributeName: aws.String("SK"), KeyType: types.KeyTypeRange},
+		},
+		AttributeDefinitions: []types.AttributeDefinition{
+			{AttributeName: aws.String("PK"), AttributeType: types.ScalarAttributeTypeS},
+			{AttributeName: aws.String("SK"), AttributeType: types.ScalarAttributeTypeN},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, svc
+}
+
+func jiraKey(pk string, sk int) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{Value: pk},
+		"SK": &types.AttributeValueMemberN{Value: strconv.Itoa(sk)},
+	}
+}
+
+func attrNumber(t *testing.T, item map[string]types.AttributeValue, attr string) string {
+	t.Helper()
+	n, ok := item[attr].(*types.AttributeValueMemberN)
+	if !ok {
+		t.Fatalf("%s is %#v, want N", attr, item[attr])
+	}
+	return n.Value
+}
+
+func errorCode(err error) string {
+	var ae smithy.APIError
+	if errors.As(err, &ae) {
+		return ae.ErrorCode()
+	}
+	return ""
+}
+
+func TestTables(t *testing.T) {
+	ctx := context.Background()
+	_, svc := newServer(t)
+	_, err := svc.CreateTable(ctx, &dynamodb.CreateTableInput{
+		TableName:   aws.String("Jira"),
+		BillingMode: types.BillingModePayPerRequest,
+		KeySchema: []types.KeySchemaElement{
+			{AttributeName: aws.String("PK"), KeyType: types.KeyTypeHash},
+		},
+		AttributeDefinitions: []types.AttributeDefinition{
+			{AttributeName: aws.String("PK"), AttributeType: types.ScalarAttributeTypeS},
+		},
+	})
+	var riue *types.ResourceInUseException
+	if !errors.As(err, &riue) {
+		t.Fatalf("second CreateTable: got %v, want ResourceInUseException", err)
+	}
+	err = dynamodb.NewTableExistsWaiter(svc).Wait(ctx, &dynamodb.DescribeTableInput{TableName: aws.String("Jira")}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = svc.UpdateTimeToLive(ctx, &dynamodb.UpdateTimeToLiveInput{
+		TableName: aws.String("Jira"),
+		TimeToLiveSpecification: &types.TimeToLiveSpecification{
+			AttributeName: aws.String("expira"),
+			Enabled:       aws.Bool(true),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ttl, err := svc.DescribeTimeToLive(ctx, &dynamodb.DescribeTimeToLiveInput{TableName: aws.String("Jira")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := ttl.TimeToLiveDescription; d.TimeToLiveStatus != types.TimeToLiveStatusEnabled || aws.ToString(d.AttributeName) != "expira" {
+		t.Fatalf("TTL %+v", d)
+	}
+	if _, err = svc.DeleteTable(ctx, &dynamodb.DeleteTableInput{TableName: aws.String("Jira")}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = svc.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String("Jira")})
+	var rnfe *types.ResourceNotFoundException
+	if !errors.As(err, &rnfe) {
+		t.Fatalf("DescribeTable after DeleteTable: got %v, want ResourceNotFoundException", err)
+	}
+}
+
+func TestConditionalPut(t *testing.T) {
+	ctx := context.Background()
+	_, svc := newServer(t)
+	put := func() error {
+		item := jiraKey("Tom Hanks", 0)
+		item["Count"] = &types.AttributeValueMemberN{Value: "0"}
+		_, err := svc.PutItem(ctx, &dynamodb.PutItemInput{
+			TableName:                           aws.String("Jira"),
+			Item:                                item,
+			ConditionExpression:                 aws.String("attribute_not_exists(#seq)"),
+			ExpressionAttributeNames:            map[string]string{"#seq": "SK"},
+			ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+		})
+		return err
+	}
+	if err := put(); err != nil {
+		t.Fatal(err)
+	}
+	err := put()
+	var ccfe *types.ConditionalCheckFailedException
+	if !errors.As(err, &ccfe) {
+		t.Fatalf("second PutItem: got %v, want ConditionalCheckFailedException", err)
+	}
+	if attrNumber(t, ccfe.Item, "Count") != "0" {
+		t.Fatalf("ConditionalCheckFailedException.Item %v", ccfe.Item)
+	}
+	// Nombres que no usa ninguna expresion
+	_, err = svc.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:                aws.String("Jira"),
+		Key:                      jiraKey("Tom Hanks", 0),
+		ProjectionExpression:     aws.String("#count"),
+		ExpressionAttributeNames: map[string]string{"#count": "Count", "#otro": "Otro"},
+	})
+	if errorCode(err) != "ValidationException" {
+		t.Fatalf("unused name: got %v, want ValidationException", err)
+	}
+}
+
+func TestUpdateExpressions(t *testing.T) {
+	ctx := context.Background()
+	_, svc := newServer(t)
+	update := func(expr string, rv types.ReturnValue, values map[string]types.AttributeValue) (map[string]types.AttributeValue, error) {
+		var names map[string]string
+		if strings.Contains(expr, "#count") {
+			names = map[string]string{"#count": "Count"}
+		}
+		out, err := svc.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+			TableName:                 aws.String("Jira"),
+			Key:                       jiraKey("Tom Hanks", 0),
+			UpdateExpression:          aws.String(expr),
+			ExpressionAttributeNames:  names,
+			ExpressionAttributeValues: values,
+			ReturnValues:              rv,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return out.Attributes, nil
+	}
+	one := map[string]types.AttributeValue{":uno": &types.AttributeValueMemberN{Value: "1"}}
+	if _, err := update("SET #count = #count + :uno", types.ReturnValueNone, one); errorCode(err) != "ValidationException" {
+		t.Fatalf("SET on missing attribute: got %v, want ValidationException", err)
+	}
+	for i := 1; i <= 3; i++ {
+		attrs, err := update("SET #count = if_not_exists(#count, :zero) + :uno", types.ReturnValueUpdatedNew,
+			map[string]types.AttributeValue{
+				":zero": &types.AttributeValueMemberN{Value: "0"},
+				":uno":  &types.AttributeValueMemberN{Value: "1"},
+			})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := attrNumber(t, attrs, "Count"); got != strconv.Itoa(i) {
+			t.Fatalf("Count %s, want %d", got, i)
+		}
+	}
+	attrs, err := update("ADD #count :dec, Tags :tags SET Info.Nombre = :n REMOVE Viejo", types.ReturnValueAllNew,
+		map[string]types.AttributeValue{
+			":dec":  &types.AttributeValueMemberN{Value: "-0.5"},
+			":tags": &types.AttributeValueMemberSS{Value: []string{"a", "b"}},
+			":n":    &types.AttributeValueMemberS{Value: "x"},
+		})
+	if errorCode(err) != "ValidationException" {
+		t.Fatalf("SET in missing map: got %v %v, want ValidationException", attrs, err)
+	}
+	attrs, err = update("ADD #count :dec, Tags :tags SET Info = :info", types.ReturnValueAllNew,
+		map[string]types.AttributeValue{
+			":dec":  &types.AttributeValueMemberN{Value: "-0.5"},
+			":tags": &types.AttributeValueMemberSS{Value: []string{"a", "b"}},
+			":info": &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{}},
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := attrNumber(t, attrs, "Count"); got != "2.5" {
+		t.Fatalf("Count %s, want 2.5", got)
+	}
+	attrs, err = update("DELETE Tags :a SET Info.Nombre = :n", types.ReturnValueUpdatedOld,
+		map[string]types.AttributeValue{
+			":a": &types.AttributeValueMemberSS{Value: []string{"a"}},
+			":n": &types.AttributeValueMemberS{Value: "x"},
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tags := attrs["Tags"].(*types.AttributeValueMemberSS).Value; len(tags) != 2 {
+		t.Fatalf("UPDATED_OLD Tags %v", tags)
+	}
+	attrs, err = update("DELETE Tags :b REMOVE Info.Nombre", types.ReturnValueAllNew,
+		map[string]types.AttributeValue{":b": &types.AttributeValueMemberSS{Value: []string{"b"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := attrs["Tags"]; ok {
+		t.Fatalf("empty set not removed: %v", attrs)
+	}
+	if info := attrs["Info"].(*types.AttributeValueMemberM).Value; len(info) != 0 {
+		t.Fatalf("Info %v", info)
+	}
+	if _, err := update("SET #count = :uno REMOVE #count", types.ReturnValueNone, one); errorCode(err) != "ValidationException" {
+		t.Fatalf("overlapping paths: got %v, want ValidationException", err)
+	}
+	_, err = svc.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String("Jira"),
+		Key:                       jiraKey("Tom Hanks", 0),
+		UpdateExpression:          aws.String("SET SK = :uno"),
+		ExpressionAttributeValues: one,
+	})
+	if errorCode(err) != "ValidationException" {
+		t.Fatalf("SET on key attribute: got %v, want ValidationException", err)
+	}
+}
+
+func TestConditions(t *testing.T) {
+	it := item{
+		"S":   {t: "S", s: "Toy Story"},
+		"N":   {t: "N", s: "10"},
+		"SS":  {t: "SS", set: []string{"a", "b"}},
+		"L":   {t: "L", l: []*value{{t: "S", s: "x"}, {t: "N", s: "1"}}},
+		"M":   {t: "M", m: map[string]*value{"k": {t: "BOOL", b: true}}},
+		"Nul": {t: "NULL", b: true},
+	}
+	values := item{
+		":toy":  {t: "S", s: "Toy"},
+		":ten":  {t: "N", s: "10"},
+		":nine": {t: "N", s: "9.5"},
+		":a":    {t: "S", s: "a"},
+		":x":    {t: "S", s: "x"},
+		":two":  {t: "N", s: "2"},
+		":typ":  {t: "S", s: "NULL"},
+	}
+	tests := map[string]bool{
+		"N = :ten":                                     true,
+		"N <> :ten":                                    false,
+		"N > :nine AND N <= :ten":                      true,
+		"N < :nine OR N >= :ten":                       true,
+		"NOT (N < :nine OR N >= :ten)":                 false,
+		"N BETWEEN :nine AND :ten":                     true,
+		"N IN (:nine, :two)":                           false,
+		"S > :toy":                                     true,
+		"S > :ten":                                     false,
+		"Falta = :ten":                                 false,
+		"Falta <> :ten":                                true,
+		"begins_with(S, :toy)":                         true,
+		"contains(S, :toy)":                            true,
+		"contains(SS, :a)":                             true,
+		"contains(L, :x)":                              true,
+		"contains(L, :a)":                              false,
+		"size(SS) = :two AND size(L) = :two":           true,
+		"size(S) > :ten":                               false,
+		"attribute_exists(M.k)":                        true,
+		"attribute_not_exists(L[2])":                   true,
+		"attribute_type(Nul, :typ)":                    true,
+		"attribute_exists(S) and not contains(SS, :x)": true,
+	}
+	for expr, want := range tests {
+		x := newExprs(nil, values)
+		c, err := x.condition("ConditionExpression", expr)
+		if err != nil {
+			t.Fatalf("%s: %v", expr, err)
+		}
+		if got := c.eval(it); got != want {
+			t.Errorf("%s: got %v, want %v", expr, got, want)
+		}
+	}
+	for _, expr := range []string{"N =", "N = :nada", "(N = :ten", "foo(N)", "N = :ten AND"} {
+		_, err := newExprs(nil, values).condition("ConditionExpression", expr)
+		if ae, ok := err.(*apiError); !ok || ae.typ != "ValidationException" {
+			t.Errorf("%s: got %v, want ValidationException", expr, err)
+		}
+	}
+}
+
+func TestQuery(t *testing.T) {
+	ctx := context.Background()
+	_, svc := newServer(t)
+	for sk := 1; sk <= 5; sk++ {
+		item := jiraKey("Tom Hanks", sk)
+		item["Movie"] = &types.AttributeValueMemberS{Value: "Movie" + strconv.Itoa(sk%2)}
+		item["Genre"] = &types.AttributeValueMemberS{Value: []string{"Drama", "Comedy"}[sk%2]}
+		if _, err := svc.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String("Jira"), Item: item}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := svc.UpdateTable(ctx, &dynamodb.UpdateTableInput{
+		TableName: aws.String("Jira"),
+		GlobalSecondaryIndexUpdates: []types.GlobalSecondaryIndexUpdate{{
+			Create: &types.CreateGlobalSecondaryIndexAction{
+				IndexName:  aws.String("Movies"),
+				Projection: &types.Projection{ProjectionType: types.ProjectionTypeKeysOnly},
+				KeySchema: []types.KeySchemaElement{
+					{AttributeName: aws.String("Movie"), KeyType: types.KeyTypeHash},
+					{AttributeName: aws.String("SK"), KeyType: types.KeyTypeRange},
+				},
+			},
+		}},
+		AttributeDefinitions: []types.AttributeDefinition{
+			{AttributeName: aws.String("Movie"), AttributeType: types.ScalarAttributeTypeS},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Paginas de 2 hacia atras, con filtro
+	var sks []int
+	var start map[string]types.AttributeValue
+	pages := 0
+	for {
+		out, err := svc.Query(ctx, &dynamodb.QueryInput{
+			TableName:              aws.String("Jira"),
+			KeyConditionExpression: aws.String("PK = :pk AND SK > :one"),
+			FilterExpression:       aws.String("Genre = :drama"),
+			ProjectionExpression:   aws.String("SK"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":pk":    &types.AttributeValueMemberS{Value: "Tom Hanks"},
+				":one":   &types.AttributeValueMemberN{Value: "1"},
+				":drama": &types.AttributeValueMemberS{Value: "Drama"},
+			},
+			Limit:             aws.Int32(2),
+			ScanIndexForward:  aws.Bool(false),
+			ExclusiveStartKey: start,
+			ConsistentRead:    aws.Bool(true),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages++
+		for _, item := range out.Items {
+			if len(item) != 1 {
+				t.Fatalf("projected item %v", item)
+			}
+			sk, _ := strconv.Atoi(attrNumber(t, item, "SK"))
+			sks = append(sks, sk)
+		}
+		if start = out.LastEvaluatedKey; start == nil {
+			break
+		}
+	}
+	if len(sks) != 2 || sks[0] != 4 || sks[1] != 2 || pages != 3 {
+		t.Fatalf("got %v in %d pages, want [4 2] in 3", sks, pages)
+	}
+
+	out, err := svc.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String("Jira"),
+		IndexName:              aws.String("Movies"),
+		KeyConditionExpression: aws.String("#movie = :movie"),
+		ExpressionAttributeNames: map[string]string{
+			"#movie": "Movie",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":movie": &types.AttributeValueMemberS{Value: "Movie1"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sks = nil
+	for _, item := range out.Items {
+		if _, ok := item["Genre"]; ok {
+			t.Fatalf("KEYS_ONLY index returned %v", item)
+		}
+		sk, _ := strconv.Atoi(attrNumber(t, item, "SK"))
+		sks = append(sks, sk)
+	}
+	if !sort.IntsAreSorted(sks) || len(sks) != 3 {
+		t.Fatalf("index query got %v, want [1 3 5]", sks)
+	}
+	_, err = svc.Query(ctx, &dynamodb.QueryInput{
+		TableName:                 aws.String("Jira"),
+		KeyConditionExpression:    aws.String("SK = :one"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{":one": &types.AttributeValueMemberN{Value: "1"}},
+	})
+	if errorCode(err) != "ValidationException" {
+		t.Fatalf("query without partition key: got %v, want ValidationException", err)
+	}
+}
+
+func TestTransactWriteItems(t *testing.T) {
+	ctx := context.Background()
+	_, svc := newServer(t)
+	counter := jiraKey("Tom Hanks", 0)
+	counter["Count"] = &types.AttributeValueMemberN{Value: "0"}
+	if _, err := svc.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String("Jira"), Item: counter}); err != nil {
+		t.Fatal(err)
+	}
+	// El patron de sequentialIds: el item n+1 y el contador, si no cambio
+	next := func(count int) error {
+		item := jiraKey("Tom Hanks", count+1)
+		item["Nombre"] = &types.AttributeValueMemberS{Value: "Toy Story"}
+		_, err := svc.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+			TransactItems: []types.TransactWriteItem{
+				{Put: &types.Put{TableName: aws.String("Jira"), Item: item}},
+				{Update: &types.Update{
+					TableName:                aws.String("Jira"),
+					Key:                      jiraKey("Tom Hanks", 0),
+					ConditionExpression:      aws.String("#count = :count"),
+					UpdateExpression:         aws.String("SET #count = #count + :uno"),
+					ExpressionAttributeNames: map[string]string{"#count": "Count"},
+					ExpressionAttributeValues: map[string]types.AttributeValue{
+						":uno":   &types.AttributeValueMemberN{Value: "1"},
+						":count": &types.AttributeValueMemberN{Value: strconv.Itoa(count)},
+					},
+					ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+				}},
+			},
+		})
+		return err
+	}
+	if err := next(0); err != nil {
+		t.Fatal(err)
+	}
+	err := next(0)
+	var tce *types.TransactionCanceledException
+	if !errors.As(err, &tce) {
+		t.Fatalf("stale transaction: got %v, want TransactionCanceledException", err)
+	}
+	if len(tce.CancellationReasons) != 2 || aws.ToString(tce.CancellationReasons[0].Code) != "None" ||
+		aws.ToString(tce.CancellationReasons[1].Code) != "ConditionalCheckFailed" ||
+		attrNumber(t, tce.CancellationReasons[1].Item, "Count") != "1" {
+		t.Fatalf("CancellationReasons %+v", tce.CancellationReasons)
+	}
+	gio, err := svc.GetItem(ctx, &dynamodb.GetItemInput{TableName: aws.String("Jira"), Key: jiraKey("Tom Hanks", 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := gio.Item["Nombre"].(*types.AttributeValueMemberS); !ok {
+		t.Fatalf("item 1 %v", gio.Item)
+	}
+	_, err = svc.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{Delete: &types.Delete{TableName: aws.String("Jira"), Key: jiraKey("Tom Hanks", 1)}},
+			{ConditionCheck: &types.ConditionCheck{
+				TableName:           aws.String("Jira"),
+				Key:                 jiraKey("Tom Hanks", 1),
+				ConditionExpression: aws.String("attribute_exists(PK)"),
+			}},
+		},
+	})
+	if errorCode(err) != "ValidationException" {
+		t.Fatalf("two operations on one item: got %v, want ValidationException", err)
+	}
+}
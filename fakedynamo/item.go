@@ -0,0 +1,664 @@
+package fakedynamo
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// legacyParams son los parametros anteriores a las expresiones, que el fake
+// no implementa: si llegan, la peticion falla en vez de ignorarlos.
+type legacyParams struct {
+	Expected            json.RawMessage
+	AttributeUpdates    json.RawMessage
+	AttributesToGet     json.RawMessage
+	KeyConditions       json.RawMessage
+	QueryFilter         json.RawMessage
+	ConditionalOperator json.RawMessage
+}
+
+func (p legacyParams) check() error {
+	for name, raw := range map[string]json.RawMessage{
+		"Expected":            p.Expected,
+		"AttributeUpdates":    p.AttributeUpdates,
+		"AttributesToGet":     p.AttributesToGet,
+		"KeyConditions":       p.KeyConditions,
+		"QueryFilter":         p.QueryFilter,
+		"ConditionalOperator": p.ConditionalOperator,
+	} {
+		if len(raw) > 0 && string(raw) != "null" {
+			return validationf("fakedynamo: legacy parameter %s is not supported, use expressions", name)
+		}
+	}
+	return nil
+}
+
+type expressionParams struct {
+	ConditionExpression                 string
+	ExpressionAttributeNames            map[string]string
+	ExpressionAttributeValues           item
+	ReturnValuesOnConditionCheckFailure string
+}
+
+type putItemInput struct {
+	legacyParams
+	expressionParams
+	TableName    string
+	Item         item
+	ReturnValues string
+}
+
+type updateItemInput struct {
+	legacyParams
+	expressionParams
+	TableName        string
+	Key              item
+	UpdateExpression string
+	ReturnValues     string
+}
+
+type deleteItemInput struct {
+	legacyParams
+	expressionParams
+	TableName    string
+	Key          item
+	ReturnValues string
+}
+
+type conditionCheckInput struct {
+	expressionParams
+	TableName string
+	Key       item
+}
+
+type getItemInput struct {
+	legacyParams
+	TableName                string
+	Key                      item
+	ProjectionExpression     string
+	ExpressionAttributeNames map[string]string
+}
+
+type queryInput struct {
+	legacyParams
+	TableName                 string
+	IndexName                 string
+	KeyConditionExpression    string
+	FilterExpression          string
+	ProjectionExpression      string
+	ExpressionAttributeNames  map[string]string
+	ExpressionAttributeValues item
+	Limit                     int
+	ExclusiveStartKey         item
+	ScanIndexForward          *bool
+	ConsistentRead            bool
+	Select                    string
+}
+
+type transactWriteItemsInput struct {
+	TransactItems []struct {
+		ConditionCheck *conditionCheckInput
+		Put            *putItemInput
+		Delete         *deleteItemInput
+		Update         *updateItemInput
+	}
+}
+
+// write es una escritura ya validada sobre un item: la de PutItem,
+// UpdateItem, DeleteItem o una de las de TransactWriteItems.
+type write struct {
+	t      *table
+	key    string
+	cond   *condNode
+	allOld bool // ReturnValuesOnConditionCheckFailure ALL_OLD
+	// change calcula el item nuevo a partir del actual (nil si no existe);
+	// devuelve nil para borrarlo. Es nil en un ConditionCheck.
+	change func(old item) (item, error)
+	paths  []path // los del update, para UPDATED_OLD y UPDATED_NEW
+}
+
+// run evalua la condicion de w y calcula el item nuevo, sin guardarlo.
+func (w *write) run() (old, new item, err error) {
+	old = w.t.items[w.key]
+	if w.cond != nil && !w.cond.eval(old) {
+		e := &apiError{typ: "ConditionalCheckFailedException", msg: "The conditional request failed"}
+		if w.allOld {
+			e.item = old
+		}
+		return old, nil, e
+	}
+	if w.change == nil {
+		return old, old, nil
+	}
+	new, err = w.change(old)
+	return old, new, err
+}
+
+func (w *write) commit(new item) {
+	switch {
+	case w.change == nil:
+	case new == nil:
+		delete(w.t.items, w.key)
+	default:
+		w.t.items[w.key] = new
+	}
+}
+
+// prepare valida lo comun de las escrituras: la tabla, la clave, la
+// condicion y ReturnValuesOnConditionCheckFailure. x ya tiene analizadas las
+// demas expresiones de la peticion.
+func (s *Server) prepare(tableName string, key item, p expressionParams, x *exprs) (*write, error) {
+	t, err := s.table(tableName)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.checkKey(key); err != nil {
+		return nil, err
+	}
+	w := &write{t: t, key: t.keyString(key)}
+	if p.ConditionExpression != "" {
+		if w.cond, err = x.condition("ConditionExpression", p.ConditionExpression); err != nil {
+			return nil, err
+		}
+	}
+	switch p.ReturnValuesOnConditionCheckFailure {
+	case "", "NONE":
+	case "ALL_OLD":
+		w.allOld = true
+	default:
+		return nil, validationf("1 validation error detected: Value '%s' at 'returnValuesOnConditionCheckFailure' failed to satisfy constraint: Member must satisfy enum value set: [ALL_OLD, NONE]", p.ReturnValuesOnConditionCheckFailure)
+	}
+	return w, x.unused()
+}
+
+func (s *Server) preparePut(in *putItemInput) (*write, error) {
+	if err := in.check(); err != nil {
+		return nil, err
+	}
+	t, err := s.table(in.TableName)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.checkItem(in.Item); err != nil {
+		return nil, err
+	}
+	key := item{}
+	for _, a := range t.key.attrs() {
+		key[a] = in.Item[a]
+	}
+	w, err := s.prepare(in.TableName, key, in.expressionParams, newExprs(in.ExpressionAttributeNames, in.ExpressionAttributeValues))
+	if err != nil {
+		return nil, err
+	}
+	w.change = func(item) (item, error) {
+		return in.Item.clone(), nil
+	}
+	return w, nil
+}
+
+func (s *Server) prepareUpdate(in *updateItemInput) (*write, error) {
+	if err := in.check(); err != nil {
+		return nil, err
+	}
+	x := newExprs(in.ExpressionAttributeNames, in.ExpressionAttributeValues)
+	u := &update{}
+	if in.UpdateExpression != "" {
+		var err error
+		if u, err = x.update(in.UpdateExpression); err != nil {
+			return nil, err
+		}
+	}
+	w, err := s.prepare(in.TableName, in.Key, in.expressionParams, x)
+	if err != nil {
+		return nil, err
+	}
+	t := w.t
+	w.paths = u.paths()
+	for _, p := range w.paths {
+		for _, a := range t.key.attrs() {
+			if p[0].name == a {
+				return nil, validationf("One or more parameter values were invalid: Cannot update attribute %s. This attribute is part of the key", a)
+			}
+		}
+	}
+	w.change = func(old item) (item, error) {
+		it := old.clone()
+		if it == nil {
+			it = in.Key.clone()
+		}
+		if err := u.apply(it); err != nil {
+			return nil, err
+		}
+		return it, t.checkItem(it)
+	}
+	return w, nil
+}
+
+func (s *Server) prepareDelete(in *deleteItemInput) (*write, error) {
+	if err := in.check(); err != nil {
+		return nil, err
+	}
+	w, err := s.prepare(in.TableName, in.Key, in.expressionParams, newExprs(in.ExpressionAttributeNames, in.ExpressionAttributeValues))
+	if err != nil {
+		return nil, err
+	}
+	w.change = func(item) (item, error) {
+		return nil, nil
+	}
+	return w, nil
+}
+
+func (s *Server) prepareCheck(in *conditionCheckInput) (*write, error) {
+	if in.ConditionExpression == "" {
+		return nil, validationf("The ConditionExpression parameter must be provided for a ConditionCheck")
+	}
+	return s.prepare(in.TableName, in.Key, in.expressionParams, newExprs(in.ExpressionAttributeNames, in.ExpressionAttributeValues))
+}
+
+// checkReturnValues valida ReturnValues contra los que admite la operacion.
+func checkReturnValues(rv string, allowed ...string) error {
+	if rv == "" {
+		return nil
+	}
+	for _, a := range allowed {
+		if rv == a {
+			return nil
+		}
+	}
+	return validationf("ReturnValues can only be %s for this operation", strings.Join(allowed, " or "))
+}
+
+func (s *Server) putItem(body []byte) (interface{}, error) {
+	var in putItemInput
+	if err := decode(body, &in); err != nil {
+		return nil, err
+	}
+	if err := checkReturnValues(in.ReturnValues, "NONE", "ALL_OLD"); err != nil {
+		return nil, err
+	}
+	w, err := s.preparePut(&in)
+	if err != nil {
+		return nil, err
+	}
+	old, new, err := w.run()
+	if err != nil {
+		return nil, err
+	}
+	w.commit(new)
+	out := map[string]interface{}{}
+	if in.ReturnValues == "ALL_OLD" && old != nil {
+		out["Attributes"] = old
+	}
+	return out, nil
+}
+
+func (s *Server) updateItem(body []byte) (interface{}, error) {
+	var in updateItemInput
+	if err := decode(body, &in); err != nil {
+		return nil, err
+	}
+	if err := checkReturnValues(in.ReturnValues, "NONE", "ALL_OLD", "UPDATED_OLD", "ALL_NEW", "UPDATED_NEW"); err != nil {
+		return nil, err
+	}
+	w, err := s.prepareUpdate(&in)
+	if err != nil {
+		return nil, err
+	}
+	old, new, err := w.run()
+	if err != nil {
+		return nil, err
+	}
+	w.commit(new)
+	var attrs item
+	switch in.ReturnValues {
+	case "ALL_OLD":
+		attrs = old
+	case "UPDATED_OLD":
+		attrs = project(old, w.paths)
+	case "ALL_NEW":
+		attrs = new
+	case "UPDATED_NEW":
+		attrs = project(new, w.paths)
+	}
+	out := map[string]interface{}{}
+	if len(attrs) > 0 {
+		out["Attributes"] = attrs
+	}
+	return out, nil
+}
+
+func (s *Server) deleteItem(body []byte) (interface{}, error) {
+	var in deleteItemInput
+	if err := decode(body, &in); err != nil {
+		return nil, err
+	}
+	if err := checkReturnValues(in.ReturnValues, "NONE", "ALL_OLD"); err != nil {
+		return nil, err
+	}
+	w, err := s.prepareDelete(&in)
+	if err != nil {
+		return nil, err
+	}
+	old, new, err := w.run()
+	if err != nil {
+		return nil, err
+	}
+	w.commit(new)
+	out := map[string]interface{}{}
+	if in.ReturnValues == "ALL_OLD" && old != nil {
+		out["Attributes"] = old
+	}
+	return out, nil
+}
+
+func (s *Server) getItem(body []byte) (interface{}, error) {
+	var in getItemInput
+	if err := decode(body, &in); err != nil {
+		return nil, err
+	}
+	if err := in.check(); err != nil {
+		return nil, err
+	}
+	t, err := s.table(in.TableName)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.checkKey(in.Key); err != nil {
+		return nil, err
+	}
+	x := newExprs(in.ExpressionAttributeNames, nil)
+	var paths []path
+	if in.ProjectionExpression != "" {
+		if paths, err = x.projection(in.ProjectionExpression); err != nil {
+			return nil, err
+		}
+	}
+	if err := x.unused(); err != nil {
+		return nil, err
+	}
+	out := map[string]interface{}{}
+	if it := t.items[t.keyString(in.Key)]; it != nil {
+		if paths != nil {
+			it = project(it, paths)
+		}
+		out["Item"] = it
+	}
+	return out, nil
+}
+
+func (s *Server) query(body []byte) (interface{}, error) {
+	var in queryInput
+	if err := decode(body, &in); err != nil {
+		return nil, err
+	}
+	if err := in.check(); err != nil {
+		return nil, err
+	}
+	t, err := s.table(in.TableName)
+	if err != nil {
+		return nil, err
+	}
+	ks := t.key
+	var idx *index
+	if in.IndexName != "" {
+		if idx = t.indexes[in.IndexName]; idx == nil {
+			return nil, validationf("The table does not have the specified index: %s", in.IndexName)
+		}
+		if in.ConsistentRead && !idx.local {
+			return nil, validationf("Consistent reads are not supported on global secondary indexes")
+		}
+		ks = idx.key
+	}
+	x := newExprs(in.ExpressionAttributeNames, in.ExpressionAttributeValues)
+	if in.KeyConditionExpression == "" {
+		return nil, validationf("Either the KeyConditions or KeyConditionExpression parameter must be specified in the request.")
+	}
+	keyCond, err := x.condition("KeyConditionExpression", in.KeyConditionExpression)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkKeyCondition(keyCond, ks); err != nil {
+		return nil, err
+	}
+	var filter *condNode
+	if in.FilterExpression != "" {
+		if filter, err = x.condition("FilterExpression", in.FilterExpression); err != nil {
+			return nil, err
+		}
+		for _, a := range ks.attrs() {
+			if filter.uses(a) {
+				return nil, validationf("Filter Expression can only contain non-primary key attributes: Primary key attribute: %s", a)
+			}
+		}
+	}
+	var paths []path
+	if in.ProjectionExpression != "" {
+		if paths, err = x.projection(in.ProjectionExpression); err != nil {
+			return nil, err
+		}
+	}
+	if err := x.unused(); err != nil {
+		return nil, err
+	}
+	if in.Limit < 0 {
+		return nil, validationf("1 validation error detected: Value '%d' at 'limit' failed to satisfy constraint: Member must have value greater than or equal to 1", in.Limit)
+	}
+
+	var found []item
+	for _, it := range t.items {
+		if (idx == nil || idx.holds(t, it)) && keyCond.eval(it) {
+			found = append(found, it)
+		}
+	}
+	order := t.order(ks)
+	sort.Slice(found, func(i, j int) bool {
+		return order(found[i], found[j]) < 0
+	})
+	if in.ScanIndexForward != nil && !*in.ScanIndexForward {
+		for i, j := 0, len(found)-1; i < j; i, j = i+1, j-1 {
+			found[i], found[j] = found[j], found[i]
+		}
+		forward := order
+		order = func(a, b item) int { return -forward(a, b) }
+	}
+	if in.ExclusiveStartKey != nil {
+		start := 0
+		for start < len(found) && order(found[start], in.ExclusiveStartKey) <= 0 {
+			start++
+		}
+		found = found[start:]
+	}
+	var lastKey item
+	if in.Limit > 0 && len(found) >= in.Limit {
+		// Como DynamoDB, devuelve LastEvaluatedKey aunque no quede nada
+		found = found[:in.Limit]
+		lastKey = item{}
+		for _, a := range append(t.key.attrs(), ks.attrs()...) {
+			lastKey[a] = found[len(found)-1][a]
+		}
+	}
+	items := []item{}
+	for _, it := range found {
+		if filter != nil && !filter.eval(it) {
+			continue
+		}
+		if idx != nil {
+			it = idx.projectIndex(t, it)
+		}
+		if paths != nil {
+			it = project(it, paths)
+		}
+		items = append(items, it)
+	}
+	out := map[string]interface{}{
+		"Count":        len(items),
+		"ScannedCount": len(found),
+	}
+	if in.Select != "COUNT" {
+		out["Items"] = items
+	}
+	if lastKey != nil {
+		out["LastEvaluatedKey"] = lastKey
+	}
+	return out, nil
+}
+
+// checkKeyCondition comprueba que c es una igualdad sobre la partition key
+// de ks y, como mucho, una condicion sobre la sort key.
+func checkKeyCondition(c *condNode, ks keySchema) error {
+	conds := []*condNode{c}
+	if c.op == "AND" {
+		conds = c.kids
+	}
+	var hash, rng bool
+	for _, c := range conds {
+		if c.op != "cmp" && c.op != "BETWEEN" && !(c.op == "func" && c.fn == "begins_with") ||
+			len(c.args[0].path) != 1 || c.args[0].size || c.args[1].path != nil {
+			return validationf("Invalid operator used in KeyConditionExpression")
+		}
+		switch name := c.args[0].path[0].name; {
+		case name == ks.hash && c.op == "cmp" && c.cmp == "=" && !hash:
+			hash = true
+		case name == ks.rng && ks.rng != "" && !rng && c.cmp != "<>":
+			rng = true
+		default:
+			return validationf("Query key condition not supported")
+		}
+	}
+	if !hash {
+		return validationf("Query condition missed key schema element: %s", ks.hash)
+	}
+	return nil
+}
+
+// order ordena los items como una Query sobre ks: por la sort key y, entre
+// iguales de un indice, por la clave de la tabla.
+func (t *table) order(ks keySchema) func(a, b item) int {
+	return func(a, b item) int {
+		for _, attr := range append([]string{ks.rng}, t.key.attrs()...) {
+			if attr == "" {
+				continue
+			}
+			if c, ok := compare(a[attr], b[attr]); ok && c != 0 {
+				return c
+			}
+		}
+		return 0
+	}
+}
+
+func (s *Server) transactWriteItems(body []byte) (interface{}, error) {
+	var in transactWriteItemsInput
+	if err := decode(body, &in); err != nil {
+		return nil, err
+	}
+	if len(in.TransactItems) == 0 || len(in.TransactItems) > 100 {
+		return nil, validationf("1 validation error detected: Value at 'transactItems' failed to satisfy constraint: Member must have length less than or equal to 100, Member must have length greater than or equal to 1")
+	}
+	writes := make([]*write, len(in.TransactItems))
+	seen := map[string]bool{}
+	for i, ti := range in.TransactItems {
+		var w *write
+		var err error
+		switch {
+		case ti.ConditionCheck != nil:
+			w, err = s.prepareCheck(ti.ConditionCheck)
+		case ti.Put != nil:
+			w, err = s.preparePut(ti.Put)
+		case ti.Delete != nil:
+			w, err = s.prepareDelete(ti.Delete)
+		case ti.Update != nil:
+			w, err = s.prepareUpdate(ti.Update)
+		default:
+			err = validationf("TransactItems can only contain one of Check, Put, Update or Delete")
+		}
+		if err != nil {
+			return nil, err
+		}
+		id := w.t.name + "\x00" + w.key
+		if seen[id] {
+			return nil, validationf("Transaction request cannot include multiple operations on one item")
+		}
+		seen[id] = true
+		writes[i] = w
+	}
+	news := make([]item, len(writes))
+	reasons := make([]cancellationReason, len(writes))
+	var codes []string
+	failed := false
+	for i, w := range writes {
+		reasons[i].Code = "None"
+		_, new, err := w.run()
+		if ae, ok := err.(*apiError); ok {
+			failed = true
+			reasons[i] = cancellationReason{Code: strings.TrimSuffix(ae.typ, "Exception"), Message: ae.msg, Item: ae.item}
+			if ae.typ == "ValidationException" {
+				reasons[i].Code = "ValidationError"
+			}
+		} else if err != nil {
+			return nil, err
+		}
+		news[i] = new
+		codes = append(codes, reasons[i].Code)
+	}
+	if failed {
+		return nil, &apiError{
+			typ:     "TransactionCanceledException",
+			msg:     "Transaction cancelled, please refer cancellation reasons for specific reasons [" + strings.Join(codes, ", ") + "]",
+			reasons: reasons,
+		}
+	}
+	for i, w := range writes {
+		w.commit(news[i])
+	}
+	return map[string]interface{}{}, nil
+}
+
+// checkKey comprueba que key es exactamente la clave de t.
+func (t *table) checkKey(key item) error {
+	if len(key) != len(t.key.attrs()) {
+		return validationf("The provided key element does not match the schema")
+	}
+	for _, a := range t.key.attrs() {
+		if v := key[a]; v == nil || v.t != t.attrs[a] {
+			return validationf("The provided key element does not match the schema")
+		}
+		if key[a].s == "" {
+			return validationf("One or more parameter values are not valid. The AttributeValue for a key attribute cannot contain an empty string value. Key: %s", a)
+		}
+	}
+	return nil
+}
+
+// checkItem comprueba la clave de la tabla y los tipos de las claves de los
+// indices en it.
+func (t *table) checkItem(it item) error {
+	for _, a := range t.key.attrs() {
+		v := it[a]
+		switch {
+		case v == nil:
+			return validationf("One or more parameter values were invalid: Missing the key %s in the item", a)
+		case v.t != t.attrs[a]:
+			return validationf("One or more parameter values were invalid: Type mismatch for key %s expected: %s actual: %s", a, t.attrs[a], v.t)
+		case v.s == "":
+			return validationf("One or more parameter values are not valid. The AttributeValue for a key attribute cannot contain an empty string value. Key: %s", a)
+		}
+	}
+	for _, name := range keysOf(t.indexes) {
+		for _, a := range t.indexes[name].key.attrs() {
+			if v := it[a]; v != nil && v.t != t.attrs[a] {
+				return validationf("One or more parameter values were invalid: Type mismatch for Index Key %s Expected: %s Actual: %s IndexName: %s", a, t.attrs[a], v.t, name)
+			}
+		}
+	}
+	return nil
+}
+
+// keyString identifica el item con la clave key en t.items.
+func (t *table) keyString(key item) string {
+	s := keyString(key[t.key.hash])
+	if t.key.rng != "" {
+		s += "\x00" + keyString(key[t.key.rng])
+	}
+	return s
+}
@@ -0,0 +1,423 @@
+package fakedynamo
+
+import (
+	"sort"
+	"time"
+)
+
+// Formas JSON de las peticiones y respuestas de tablas. Los campos que el
+// fake no usa se ignoran.
+
+type keySchemaElement struct {
+	AttributeName string
+	KeyType       string
+}
+
+type attributeDefinition struct {
+	AttributeName string
+	AttributeType string
+}
+
+type projection struct {
+	ProjectionType   string
+	NonKeyAttributes []string `json:",omitempty"`
+}
+
+type provisionedThroughput struct {
+	ReadCapacityUnits  int64
+	WriteCapacityUnits int64
+}
+
+type indexInput struct {
+	IndexName  string
+	KeySchema  []keySchemaElement
+	Projection projection
+}
+
+type createTableInput struct {
+	TableName              string
+	KeySchema              []keySchemaElement
+	AttributeDefinitions   []attributeDefinition
+	BillingMode            string
+	ProvisionedThroughput  *provisionedThroughput
+	GlobalSecondaryIndexes []indexInput
+	LocalSecondaryIndexes  []indexInput
+}
+
+type updateTableInput struct {
+	TableName                   string
+	AttributeDefinitions        []attributeDefinition
+	BillingMode                 string
+	ProvisionedThroughput       *provisionedThroughput
+	GlobalSecondaryIndexUpdates []struct {
+		Create *indexInput
+		Delete *struct{ IndexName string }
+	}
+}
+
+type tableNameInput struct {
+	TableName string
+}
+
+type updateTimeToLiveInput struct {
+	TableName               string
+	TimeToLiveSpecification struct {
+		AttributeName string
+		Enabled       bool
+	}
+}
+
+type indexDescription struct {
+	IndexName   string
+	KeySchema   []keySchemaElement
+	Projection  projection
+	IndexStatus string `json:",omitempty"`
+	ItemCount   int
+	IndexArn    string
+}
+
+type tableDescription struct {
+	TableName              string
+	TableArn               string
+	TableStatus            string
+	CreationDateTime       float64
+	KeySchema              []keySchemaElement
+	AttributeDefinitions   []attributeDefinition
+	BillingModeSummary     struct{ BillingMode string }
+	ProvisionedThroughput  provisionedThroughput
+	ItemCount              int
+	TableSizeBytes         int
+	GlobalSecondaryIndexes []indexDescription `json:",omitempty"`
+	LocalSecondaryIndexes  []indexDescription `json:",omitempty"`
+}
+
+// keySchema son los nombres de los atributos de una clave; rng es "" si no
+// tiene sort key.
+type keySchema struct {
+	hash, rng string
+}
+
+func (k keySchema) attrs() []string {
+	if k.rng == "" {
+		return []string{k.hash}
+	}
+	return []string{k.hash, k.rng}
+}
+
+func (k keySchema) elements() []keySchemaElement {
+	ks := []keySchemaElement{{k.hash, "HASH"}}
+	if k.rng != "" {
+		ks = append(ks, keySchemaElement{k.rng, "RANGE"})
+	}
+	return ks
+}
+
+type index struct {
+	name  string
+	key   keySchema
+	proj  projection
+	local bool
+}
+
+type table struct {
+	name        string
+	key         keySchema
+	attrs       map[string]string // AttributeDefinitions
+	indexes     map[string]*index
+	items       map[string]item
+	billing     string
+	provisioned provisionedThroughput
+	ttlAttr     string // "" sin TTL
+	created     time.Time
+}
+
+func (s *Server) table(name string) (*table, error) {
+	t, ok := s.tables[name]
+	if !ok {
+		return nil, &apiError{typ: "ResourceNotFoundException", msg: "Requested resource not found: Table: " + name + " not found"}
+	}
+	return t, nil
+}
+
+// keySchemaOf lee y valida el KeySchema de una tabla o indice.
+func keySchemaOf(ks []keySchemaElement, attrs map[string]string) (keySchema, error) {
+	var k keySchema
+	if len(ks) == 0 || len(ks) > 2 || ks[0].KeyType != "HASH" || (len(ks) == 2 && ks[1].KeyType != "RANGE") {
+		return k, validationf("1 validation error detected: Value at 'keySchema' failed to satisfy constraint: Member must have a HASH key first and at most one RANGE key")
+	}
+	k.hash = ks[0].AttributeName
+	if len(ks) == 2 {
+		k.rng = ks[1].AttributeName
+	}
+	for _, a := range k.attrs() {
+		switch attrs[a] {
+		case "S", "N", "B":
+		case "":
+			return k, validationf("One or more parameter values were invalid: Some index key attributes are not defined in AttributeDefinitions. Keys: [%s], AttributeDefinitions: %v", a, keysOf(attrs))
+		default:
+			return k, validationf("Member must satisfy enum value set: [B, N, S]")
+		}
+	}
+	return k, nil
+}
+
+func newIndex(in indexInput, attrs map[string]string, local bool) (*index, error) {
+	k, err := keySchemaOf(in.KeySchema, attrs)
+	if err != nil {
+		return nil, err
+	}
+	switch in.Projection.ProjectionType {
+	case "ALL", "KEYS_ONLY", "INCLUDE":
+	default:
+		return nil, validationf("One or more parameter values were invalid: Unknown ProjectionType: %q", in.Projection.ProjectionType)
+	}
+	return &index{name: in.IndexName, key: k, proj: in.Projection, local: local}, nil
+}
+
+func (s *Server) createTable(body []byte) (interface{}, error) {
+	var in createTableInput
+	if err := decode(body, &in); err != nil {
+		return nil, err
+	}
+	if in.TableName == "" {
+		return nil, validationf("1 validation error detected: Value null at 'tableName' failed to satisfy constraint: Member must not be null")
+	}
+	if _, ok := s.tables[in.TableName]; ok {
+		return nil, &apiError{typ: "ResourceInUseException", msg: "Table already exists: " + in.TableName}
+	}
+	if in.BillingMode != "PAY_PER_REQUEST" && in.ProvisionedThroughput == nil {
+		return nil, validationf("One or more parameter values were invalid: ReadCapacityUnits and WriteCapacityUnits must both be specified when BillingMode is PROVISIONED")
+	}
+	t := &table{
+		name:    in.TableName,
+		attrs:   map[string]string{},
+		indexes: map[string]*index{},
+		items:   map[string]item{},
+		billing: in.BillingMode,
+		created: time.Now(),
+	}
+	if t.billing == "" {
+		t.billing = "PROVISIONED"
+	}
+	if in.ProvisionedThroughput != nil {
+		t.provisioned = *in.ProvisionedThroughput
+	}
+	for _, ad := range in.AttributeDefinitions {
+		t.attrs[ad.AttributeName] = ad.AttributeType
+	}
+	var err error
+	if t.key, err = keySchemaOf(in.KeySchema, t.attrs); err != nil {
+		return nil, err
+	}
+	used := map[string]bool{}
+	for _, a := range t.key.attrs() {
+		used[a] = true
+	}
+	for i, list := range [][]indexInput{in.GlobalSecondaryIndexes, in.LocalSecondaryIndexes} {
+		for _, ii := range list {
+			idx, err := newIndex(ii, t.attrs, i == 1)
+			if err != nil {
+				return nil, err
+			}
+			if idx.local && idx.key.hash != t.key.hash {
+				return nil, validationf("One or more parameter values were invalid: Index KeySchema does not have the same leading hash key as table KeySchema for index: %s", idx.name)
+			}
+			if _, ok := t.indexes[idx.name]; ok {
+				return nil, validationf("One or more parameter values were invalid: Duplicate index name: %s", idx.name)
+			}
+			t.indexes[idx.name] = idx
+			for _, a := range idx.key.attrs() {
+				used[a] = true
+			}
+		}
+	}
+	if len(used) != len(t.attrs) {
+		return nil, validationf("One or more parameter values were invalid: Number of attributes in KeySchema does not exactly match number of attributes defined in AttributeDefinitions")
+	}
+	s.tables[t.name] = t
+	return map[string]interface{}{"TableDescription": t.describe("ACTIVE")}, nil
+}
+
+func (s *Server) updateTable(body []byte) (interface{}, error) {
+	var in updateTableInput
+	if err := decode(body, &in); err != nil {
+		return nil, err
+	}
+	t, err := s.table(in.TableName)
+	if err != nil {
+		return nil, err
+	}
+	attrs := map[string]string{}
+	for a, typ := range t.attrs {
+		attrs[a] = typ
+	}
+	for _, ad := range in.AttributeDefinitions {
+		if old, ok := attrs[ad.AttributeName]; ok && old != ad.AttributeType {
+			return nil, validationf("One or more parameter values were invalid: Attribute %s is already defined with type %s", ad.AttributeName, old)
+		}
+		attrs[ad.AttributeName] = ad.AttributeType
+	}
+	indexes := map[string]*index{}
+	for name, idx := range t.indexes {
+		indexes[name] = idx
+	}
+	for _, u := range in.GlobalSecondaryIndexUpdates {
+		switch {
+		case u.Create != nil:
+			if _, ok := indexes[u.Create.IndexName]; ok {
+				return nil, validationf("One or more parameter values were invalid: Attempting to create an index which already exists")
+			}
+			idx, err := newIndex(*u.Create, attrs, false)
+			if err != nil {
+				return nil, err
+			}
+			indexes[idx.name] = idx
+		case u.Delete != nil:
+			if idx, ok := indexes[u.Delete.IndexName]; !ok || idx.local {
+				return nil, &apiError{typ: "ResourceNotFoundException", msg: "Requested resource not found: Index: " + u.Delete.IndexName + " not found"}
+			}
+			delete(indexes, u.Delete.IndexName)
+		}
+	}
+	t.attrs, t.indexes = attrs, indexes
+	if in.BillingMode != "" {
+		t.billing = in.BillingMode
+	}
+	if in.ProvisionedThroughput != nil {
+		t.provisioned = *in.ProvisionedThroughput
+	}
+	return map[string]interface{}{"TableDescription": t.describe("ACTIVE")}, nil
+}
+
+func (s *Server) deleteTable(body []byte) (interface{}, error) {
+	var in tableNameInput
+	if err := decode(body, &in); err != nil {
+		return nil, err
+	}
+	t, err := s.table(in.TableName)
+	if err != nil {
+		return nil, err
+	}
+	delete(s.tables, t.name)
+	return map[string]interface{}{"TableDescription": t.describe("DELETING")}, nil
+}
+
+func (s *Server) describeTable(body []byte) (interface{}, error) {
+	var in tableNameInput
+	if err := decode(body, &in); err != nil {
+		return nil, err
+	}
+	t, err := s.table(in.TableName)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"Table": t.describe("ACTIVE")}, nil
+}
+
+func (s *Server) updateTimeToLive(body []byte) (interface{}, error) {
+	var in updateTimeToLiveInput
+	if err := decode(body, &in); err != nil {
+		return nil, err
+	}
+	t, err := s.table(in.TableName)
+	if err != nil {
+		return nil, err
+	}
+	spec := in.TimeToLiveSpecification
+	switch {
+	case spec.Enabled && t.ttlAttr != "":
+		return nil, validationf("TimeToLive is already enabled")
+	case !spec.Enabled && t.ttlAttr == "":
+		return nil, validationf("TimeToLive is already disabled")
+	case !spec.Enabled && spec.AttributeName != t.ttlAttr:
+		return nil, validationf("TimeToLive is active on a different AttributeName: current AttributeName is %s", t.ttlAttr)
+	case spec.Enabled:
+		t.ttlAttr = spec.AttributeName
+	default:
+		t.ttlAttr = ""
+	}
+	return map[string]interface{}{"TimeToLiveSpecification": spec}, nil
+}
+
+func (s *Server) describeTimeToLive(body []byte) (interface{}, error) {
+	var in tableNameInput
+	if err := decode(body, &in); err != nil {
+		return nil, err
+	}
+	t, err := s.table(in.TableName)
+	if err != nil {
+		return nil, err
+	}
+	d := map[string]string{"TimeToLiveStatus": "DISABLED"}
+	if t.ttlAttr != "" {
+		d = map[string]string{"TimeToLiveStatus": "ENABLED", "AttributeName": t.ttlAttr}
+	}
+	return map[string]interface{}{"TimeToLiveDescription": d}, nil
+}
+
+func (t *table) arn() string {
+	return "arn:aws:dynamodb:" + region + ":000000000000:table/" + t.name
+}
+
+func (t *table) describe(status string) tableDescription {
+	d := tableDescription{
+		TableName:             t.name,
+		TableArn:              t.arn(),
+		TableStatus:           status,
+		CreationDateTime:      float64(t.created.UnixMilli()) / 1000,
+		KeySchema:             t.key.elements(),
+		ProvisionedThroughput: t.provisioned,
+		ItemCount:             len(t.items),
+	}
+	d.BillingModeSummary.BillingMode = t.billing
+	for a, typ := range t.attrs {
+		d.AttributeDefinitions = append(d.AttributeDefinitions, attributeDefinition{a, typ})
+	}
+	sort.Slice(d.AttributeDefinitions, func(i, j int) bool {
+		return d.AttributeDefinitions[i].AttributeName < d.AttributeDefinitions[j].AttributeName
+	})
+	for _, name := range keysOf(t.indexes) {
+		idx := t.indexes[name]
+		id := indexDescription{
+			IndexName:  idx.name,
+			KeySchema:  idx.key.elements(),
+			Projection: idx.proj,
+			IndexArn:   t.arn() + "/index/" + idx.name,
+		}
+		for _, it := range t.items {
+			if idx.holds(t, it) {
+				id.ItemCount++
+			}
+		}
+		if idx.local {
+			d.LocalSecondaryIndexes = append(d.LocalSecondaryIndexes, id)
+		} else {
+			id.IndexStatus = "ACTIVE"
+			d.GlobalSecondaryIndexes = append(d.GlobalSecondaryIndexes, id)
+		}
+	}
+	return d
+}
+
+// holds indica si it esta en el indice: si tiene su clave con los tipos
+// definidos.
+func (idx *index) holds(t *table, it item) bool {
+	for _, a := range idx.key.attrs() {
+		if v := it[a]; v == nil || v.t != t.attrs[a] {
+			return false
+		}
+	}
+	return true
+}
+
+// projectIndex deja de it lo que proyecta el indice.
+func (idx *index) projectIndex(t *table, it item) item {
+	if idx.proj.ProjectionType == "ALL" {
+		return it
+	}
+	var paths []path
+	for _, a := range append(append(t.key.attrs(), idx.key.attrs()...), idx.proj.NonKeyAttributes...) {
+		paths = append(paths, path{{name: a}})
+	}
+	return project(it, paths)
+}
@@ -0,0 +1,309 @@
+package fakedynamo
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// value es un AttributeValue en el formato JSON del protocolo de DynamoDB.
+type value struct {
+	t   string // S, N, B, BOOL, NULL, SS, NS, BS, L o M
+	s   string // S, N (normalizado) y B (los bytes tal cual)
+	b   bool   // BOOL y NULL
+	set []string
+	l   []*value
+	m   map[string]*value
+}
+
+// item es un item, una clave o un mapa de valores de expresion.
+type item map[string]*value
+
+func (v *value) UnmarshalJSON(data []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if len(raw) != 1 {
+		return errors.New("Supplied AttributeValue has more than one datatypes set, must contain exactly one of the supported datatypes")
+	}
+	for t, data := range raw {
+		v.t = t
+		switch t {
+		case "S":
+			return json.Unmarshal(data, &v.s)
+		case "N":
+			var s string
+			if err := json.Unmarshal(data, &s); err != nil {
+				return err
+			}
+			n, err := parseNumber(s)
+			if err != nil {
+				return err
+			}
+			v.s = formatNumber(n)
+		case "B":
+			var b []byte
+			if err := json.Unmarshal(data, &b); err != nil {
+				return err
+			}
+			v.s = string(b)
+		case "BOOL":
+			return json.Unmarshal(data, &v.b)
+		case "NULL":
+			if err := json.Unmarshal(data, &v.b); err != nil {
+				return err
+			}
+			if !v.b {
+				return errors.New("Null attribute value types must have the value of true")
+			}
+		case "SS", "NS", "BS":
+			return v.unmarshalSet(data)
+		case "L":
+			return json.Unmarshal(data, &v.l)
+		case "M":
+			return json.Unmarshal(data, &v.m)
+		default:
+			return fmt.Errorf("Supplied AttributeValue has unknown datatype %s", t)
+		}
+	}
+	return nil
+}
+
+func (v *value) unmarshalSet(data []byte) error {
+	if v.t == "BS" {
+		var bs [][]byte
+		if err := json.Unmarshal(data, &bs); err != nil {
+			return err
+		}
+		for _, b := range bs {
+			v.set = append(v.set, string(b))
+		}
+	} else if err := json.Unmarshal(data, &v.set); err != nil {
+		return err
+	}
+	if len(v.set) == 0 {
+		kind := map[string]string{"SS": "string", "NS": "number", "BS": "binary"}[v.t]
+		return fmt.Errorf("One or more parameter values were invalid: An %s set may not be empty", kind)
+	}
+	seen := map[string]bool{}
+	for i, s := range v.set {
+		if v.t == "NS" {
+			n, err := parseNumber(s)
+			if err != nil {
+				return err
+			}
+			s = formatNumber(n)
+			v.set[i] = s
+		}
+		if seen[s] {
+			return errors.New("One or more parameter values were invalid: Input collection contains duplicates")
+		}
+		seen[s] = true
+	}
+	return nil
+}
+
+func (v *value) MarshalJSON() ([]byte, error) {
+	var payload interface{}
+	switch v.t {
+	case "S", "N":
+		payload = v.s
+	case "B":
+		payload = []byte(v.s)
+	case "BOOL", "NULL":
+		payload = v.b
+	case "SS", "NS":
+		payload = v.set
+	case "BS":
+		bs := make([]string, len(v.set))
+		for i, s := range v.set {
+			bs[i] = base64.StdEncoding.EncodeToString([]byte(s))
+		}
+		payload = bs
+	case "L":
+		l := v.l
+		if l == nil {
+			l = []*value{}
+		}
+		payload = l
+	case "M":
+		m := v.m
+		if m == nil {
+			m = map[string]*value{}
+		}
+		payload = m
+	default:
+		return nil, fmt.Errorf("fakedynamo: attribute value without type")
+	}
+	return json.Marshal(map[string]interface{}{v.t: payload})
+}
+
+// parseNumber lee un N de DynamoDB.
+func parseNumber(s string) (*big.Rat, error) {
+	// Rat acepta tambien fracciones y prefijos de base, que DynamoDB no
+	n, ok := new(big.Rat).SetString(s)
+	if !ok || strings.Trim(s, "0123456789+-.eE") != "" {
+		return nil, fmt.Errorf("A value provided cannot be converted into a number")
+	}
+	return n, nil
+}
+
+// formatNumber escribe n como lo devuelve DynamoDB, sin ceros de sobra.
+func formatNumber(n *big.Rat) string {
+	if n.IsInt() {
+		return n.Num().String()
+	}
+	s := strings.TrimRight(n.FloatString(38), "0")
+	return strings.TrimSuffix(s, ".")
+}
+
+func number(v *value) *big.Rat {
+	n, _ := new(big.Rat).SetString(v.s)
+	return n
+}
+
+func (v *value) clone() *value {
+	if v == nil {
+		return nil
+	}
+	c := *v
+	if v.set != nil {
+		c.set = append([]string(nil), v.set...)
+	}
+	if v.l != nil {
+		c.l = make([]*value, len(v.l))
+		for i, e := range v.l {
+			c.l[i] = e.clone()
+		}
+	}
+	if v.m != nil {
+		c.m = make(map[string]*value, len(v.m))
+		for k, e := range v.m {
+			c.m[k] = e.clone()
+		}
+	}
+	return &c
+}
+
+func (it item) clone() item {
+	if it == nil {
+		return nil
+	}
+	c := make(item, len(it))
+	for k, v := range it {
+		c[k] = v.clone()
+	}
+	return c
+}
+
+// equal compara como el = de las expresiones.
+func equal(a, b *value) bool {
+	if a == nil || b == nil || a.t != b.t {
+		return false
+	}
+	switch a.t {
+	case "S", "N", "B":
+		return a.s == b.s
+	case "BOOL", "NULL":
+		return a.b == b.b
+	case "SS", "NS", "BS":
+		if len(a.set) != len(b.set) {
+			return false
+		}
+		for _, s := range a.set {
+			if !containsString(b.set, s) {
+				return false
+			}
+		}
+		return true
+	case "L":
+		if len(a.l) != len(b.l) {
+			return false
+		}
+		for i := range a.l {
+			if !equal(a.l[i], b.l[i]) {
+				return false
+			}
+		}
+		return true
+	case "M":
+		if len(a.m) != len(b.m) {
+			return false
+		}
+		for k, v := range a.m {
+			if !equal(v, b.m[k]) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// compare ordena dos escalares del mismo tipo (N, S o B); ok es false si no
+// se pueden comparar.
+func compare(a, b *value) (c int, ok bool) {
+	if a == nil || b == nil || a.t != b.t {
+		return 0, false
+	}
+	switch a.t {
+	case "N":
+		return number(a).Cmp(number(b)), true
+	case "S", "B":
+		return strings.Compare(a.s, b.s), true
+	}
+	return 0, false
+}
+
+// keyString es la representacion de un valor de clave en los mapas de items.
+func keyString(v *value) string {
+	if v == nil {
+		return ""
+	}
+	return v.t + ":" + v.s
+}
+
+func containsString(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// scalarIn indica si el escalar e pertenece al set v.
+func scalarIn(v, e *value) bool {
+	return e != nil && v.t == e.t+"S" && containsString(v.set, e.s)
+}
+
+// contains es la funcion contains de las expresiones.
+func contains(v, e *value) bool {
+	switch {
+	case v == nil || e == nil:
+		return false
+	case v.t == "S" && e.t == "S", v.t == "B" && e.t == "B":
+		return strings.Contains(v.s, e.s)
+	case v.t == "SS" || v.t == "NS" || v.t == "BS":
+		return scalarIn(v, e)
+	case v.t == "L":
+		for _, x := range v.l {
+			if equal(x, e) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// beginsWith es la funcion begins_with de las expresiones.
+func beginsWith(v, prefix *value) bool {
+	if v == nil || prefix == nil || v.t != prefix.t || (v.t != "S" && v.t != "B") {
+		return false
+	}
+	return strings.HasPrefix(v.s, prefix.s)
+}
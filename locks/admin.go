@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"dynamodb/locks/locke"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Subcomandos de administracion de LockTable:
+//
+//	locks list -table Usuarios [-limit 50] [-json]
+//	locks describe -table Usuarios -value Pepe [-json]
+//	locks force-release -table Usuarios -value Pepe [-yes] [-json]
+//	locks steal -table Usuarios -value Pepe -type Admin -duration 5m [-yes] [-json]
+//	locks purge -table Usuarios [-yes] [-json]
+//
+// Las acciones destructivas piden confirmacion salvo con -yes.
+
+const usage = `usage: locks <command> [flags]
+
+commands:
+  list           list held locks of a table
+  describe       show the state of one lock
+  force-release  release a lock whatever its holder
+  steal          take a lock whatever its holder, with a new fence
+  purge          delete expired lock items of a table
+
+run "locks <command> -h" for the flags of a command.
+Without a command the -c, -l and -d flags work as before.`
+
+// lockView es la salida de un lock, en texto o JSON.
+type lockView struct {
+	Table        string    `json:"table"`
+	LockValue    string    `json:"lockValue"`
+	Held         bool      `json:"held"`
+	Owner        string    `json:"owner,omitempty"`
+	LockName     string    `json:"lockName,omitempty"`
+	LockType     string    `json:"lockType,omitempty"`
+	Fence        string    `json:"fence,omitempty"`
+	Readers      []string  `json:"readers,omitempty"`
+	StartingTime time.Time `json:"startingTime"`
+	ReleaseTime  time.Time `json:"releaseTime"`
+	Remaining    string    `json:"remaining"`
+}
+
+func newLockView(info locke.LockInfo, now time.Time) lockView {
+	return lockView{
+		Table:        info.Table,
+		LockValue:    info.LockValue,
+		Held:         info.Held(now),
+		Owner:        info.Owner,
+		LockName:     info.LockName,
+		LockType:     info.LockType,
+		Fence:        info.Fence,
+		Readers:      info.Readers,
+		StartingTime: info.StartingTime,
+		ReleaseTime:  info.ReleaseTime,
+		Remaining:    info.Remaining(now).Truncate(time.Second).String(),
+	}
+}
+
+// cli ejecuta los subcomandos contra svc, leyendo las confirmaciones de in.
+// La salida va a out; las preguntas y el uso a errOut, para no mezclarlos
+// con la salida JSON.
+type cli struct {
+	svc    locke.PurgeClient
+	in     io.Reader
+	out    io.Writer
+	errOut io.Writer
+}
+
+// command es el estado comun de un subcomando.
+type command struct {
+	fs        *flag.FlagSet
+	lockTable *string
+	table     *string
+	value     *string
+	asJSON    *bool
+	yes       *bool
+	in        io.Reader
+	out       io.Writer
+	errOut    io.Writer
+	needVal   bool
+}
+
+func (e *cli) newCommand(name string, needValue, destructive bool) *command {
+	c := &command{
+		fs:      flag.NewFlagSet(name, flag.ContinueOnError),
+		in:      e.in,
+		out:     e.out,
+		errOut:  e.errOut,
+		needVal: needValue,
+	}
+	c.fs.SetOutput(e.errOut)
+	c.lockTable = c.fs.String("lock-table", lockTable, "DynamoDB table holding the locks")
+	c.table = c.fs.String("table", "", "table of the lock (required)")
+	c.asJSON = c.fs.Bool("json", false, "JSON output")
+	if needValue {
+		c.value = c.fs.String("value", "", "lock value (required)")
+	}
+	if destructive {
+		c.yes = c.fs.Bool("yes", false, "do not ask for confirmation")
+	}
+	return c
+}
+
+func (c *command) parse(args []string) error {
+	if err := c.fs.Parse(args); err != nil {
+		return err
+	}
+	if *c.table == "" || (c.needVal && *c.value == "") {
+		c.fs.Usage()
+		return errors.New("error: missing required flags")
+	}
+	return nil
+}
+
+// opts son las opciones de locke comunes a todos los subcomandos.
+func (c *command) opts() []locke.Option {
+	return []locke.Option{locke.WithTableName(*c.lockTable)}
+}
+
+// confirm pregunta antes de una accion destructiva; con -yes no pregunta.
+func (c *command) confirm(action string) error {
+	if *c.yes {
+		return nil
+	}
+	// A errOut, para no mezclarlo con la salida JSON
+	fmt.Fprintf(c.errOut, "%s? [y/N] ", action)
+	answer, _ := bufio.NewReader(c.in).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	}
+	return errors.New("error: aborted")
+}
+
+// print escribe v como JSON con -json, o text en otro caso.
+func (c *command) print(v interface{}, text func(w io.Writer)) error {
+	if *c.asJSON {
+		enc := json.NewEncoder(c.out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	w := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	text(w)
+	return w.Flush()
+}
+
+func printLocks(w io.Writer, locks []lockView) {
+	fmt.Fprintln(w, "TABLE\tVALUE\tOWNER\tNAME\tTYPE\tFENCE\tREADERS\tREMAINING")
+	for _, l := range locks {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%d\t%s\n",
+			l.Table, l.LockValue, l.Owner, l.LockName, l.LockType, l.Fence, len(l.Readers), l.Remaining)
+	}
+}
+
+func (e *cli) run(ctx context.Context, name string, args []string) error {
+	switch name {
+	case "list":
+		return e.listCmd(ctx, args)
+	case "describe":
+		return e.describeCmd(ctx, args)
+	case "force-release":
+		return e.forceReleaseCmd(ctx, args)
+	case "steal":
+		return e.stealCmd(ctx, args)
+	case "purge":
+		return e.purgeCmd(ctx, args)
+	case "help":
+		fmt.Fprintln(e.out, usage)
+		return nil
+	}
+	fmt.Fprintln(e.errOut, usage)
+	return fmt.Errorf("error: unknown command %q", name)
+}
+
+func (e *cli) listCmd(ctx context.Context, args []string) error {
+	c := e.newCommand("list", false, false)
+	limit := c.fs.Int("limit", 0, "maximum number of locks, 0 for all")
+	if err := c.parse(args); err != nil {
+		return err
+	}
+	now := time.Now()
+	locks := []lockView{}
+	start := ""
+	for {
+		page, next, err := locke.List(ctx, e.svc, *c.table, 0, start, c.opts()...)
+		if err != nil {
+			return err
+		}
+		for _, info := range page {
+			locks = append(locks, newLockView(info, now))
+		}
+		if next == "" || (*limit > 0 && len(locks) >= *limit) {
+			break
+		}
+		start = next
+	}
+	if *limit > 0 && len(locks) > *limit {
+		locks = locks[:*limit]
+	}
+	return c.print(locks, func(w io.Writer) { printLocks(w, locks) })
+}
+
+func (e *cli) describeCmd(ctx context.Context, args []string) error {
+	c := e.newCommand("describe", true, false)
+	if err := c.parse(args); err != nil {
+		return err
+	}
+	info, err := locke.Describe(ctx, e.svc, *c.table, *c.value, c.opts()...)
+	if err != nil {
+		return err
+	}
+	v := newLockView(info, time.Now())
+	return c.print(v, func(w io.Writer) { printLocks(w, []lockView{v}) })
+}
+
+func (e *cli) forceReleaseCmd(ctx context.Context, args []string) error {
+	c := e.newCommand("force-release", true, true)
+	if err := c.parse(args); err != nil {
+		return err
+	}
+	if err := c.confirm(fmt.Sprintf("Force release %s/%s", *c.table, *c.value)); err != nil {
+		return err
+	}
+	info, err := locke.ForceRelease(ctx, e.svc, *c.table, *c.value, c.opts()...)
+	if err != nil {
+		return err
+	}
+	// Lo que habia antes de liberarlo
+	v := newLockView(info, time.Now())
+	return c.print(v, func(w io.Writer) {
+		fmt.Fprintln(w, "released, previous state:")
+		printLocks(w, []lockView{v})
+	})
+}
+
+func (e *cli) stealCmd(ctx context.Context, args []string) error {
+	c := e.newCommand("steal", true, true)
+	lockType := c.fs.String("type", "admin", "lock type to record")
+	duration := c.fs.Duration("duration", time.Minute, "lease of the stolen lock")
+	owner := c.fs.String("owner", locke.DefaultOwner(), "owner to record")
+	perLock := c.fs.Bool("per-lock-fence", false, "take the fence from the lock's own counter")
+	if err := c.parse(args); err != nil {
+		return err
+	}
+	if err := c.confirm(fmt.Sprintf("Steal %s/%s for %s", *c.table, *c.value, *duration)); err != nil {
+		return err
+	}
+	opts := append(c.opts(), locke.WithOwner(*owner))
+	if *perLock {
+		opts = append(opts, locke.WithPerLockFence())
+	}
+	if _, err := locke.Steal(ctx, e.svc, *c.table, *c.value, *lockType, *duration, opts...); err != nil {
+		return err
+	}
+	info, err := locke.Describe(ctx, e.svc, *c.table, *c.value, c.opts()...)
+	if err != nil {
+		return err
+	}
+	v := newLockView(info, time.Now())
+	return c.print(v, func(w io.Writer) { printLocks(w, []lockView{v}) })
+}
+
+func (e *cli) purgeCmd(ctx context.Context, args []string) error {
+	c := e.newCommand("purge", false, true)
+	if err := c.parse(args); err != nil {
+		return err
+	}
+	if err := c.confirm(fmt.Sprintf("Delete expired locks of %s", *c.table)); err != nil {
+		return err
+	}
+	n, err := locke.Purge(ctx, e.svc, *c.table, c.opts()...)
+	if err != nil {
+		return err
+	}
+	res := struct {
+		Table  string `json:"table"`
+		Purged int    `json:"purged"`
+	}{*c.table, n}
+	return c.print(res, func(w io.Writer) { fmt.Fprintf(w, "purged %d expired locks of %s\n", n, *c.table) })
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"dynamodb/fakedynamo"
+	"dynamodb/locks/locke"
+	"dynamodb/locks/locke/locketest"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestCLI(t *testing.T) (*dynamodb.Client, *bytes.Buffer, func(in string, args ...string) error) {
+	s := fakedynamo.New()
+	t.Cleanup(s.Close)
+	client := s.Client()
+	if err := locke.EnsureTable(context.Background(), client, lockTable); err != nil {
+		t.Fatal(err)
+	}
+	out := &bytes.Buffer{}
+	run := func(in string, args ...string) error {
+		out.Reset()
+		e := &cli{svc: client, in: strings.NewReader(in), out: out, errOut: &bytes.Buffer{}}
+		return e.run(context.Background(), args[0], args[1:])
+	}
+	return client, out, run
+}
+
+func acquire(t *testing.T, client *dynamodb.Client, value string, opts ...locke.Option) locke.Lock {
+	l, err := locke.NewLock("dynamo", client, "Usuarios", value, "Lock1", time.Minute, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Acquire(); err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func TestAdminListDescribe(t *testing.T) {
+	client, out, run := newTestCLI(t)
+	acquire(t, client, "Pepe", locke.WithOwner("worker-1"))
+	acquire(t, client, "Juan")
+
+	assert.NoError(t, run("", "list", "-table", "Usuarios", "-json"))
+	var locks []lockView
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &locks))
+	if assert.Len(t, locks, 2) {
+		assert.Equal(t, "Juan", locks[0].LockValue)
+		assert.Equal(t, "Pepe", locks[1].LockValue)
+		assert.True(t, locks[1].Held)
+	}
+	assert.NoError(t, run("", "list", "-table", "Usuarios", "-limit", "1", "-json"))
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &locks))
+	assert.Len(t, locks, 1)
+
+	assert.NoError(t, run("", "describe", "-table", "Usuarios", "-value", "Pepe", "-json"))
+	var v lockView
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &v))
+	assert.Equal(t, "worker-1", v.Owner)
+	assert.Equal(t, "Pepe->Lock1", v.LockName)
+	assert.True(t, v.Held)
+
+	// Sin -json sale la tabla de texto
+	assert.NoError(t, run("", "describe", "-table", "Usuarios", "-value", "Pepe"))
+	assert.Contains(t, out.String(), "worker-1")
+}
+
+func TestAdminConfirm(t *testing.T) {
+	client, out, run := newTestCLI(t)
+	l := acquire(t, client, "Pepe")
+
+	assert.EqualError(t, run("n\n", "force-release", "-table", "Usuarios", "-value", "Pepe"), "error: aborted")
+	assert.EqualError(t, run("", "force-release", "-table", "Usuarios", "-value", "Pepe"), "error: aborted")
+	assert.NoError(t, l.NewDuration(time.Minute), "lock released without confirmation")
+
+	assert.NoError(t, run("y\n", "force-release", "-table", "Usuarios", "-value", "Pepe", "-json"))
+	var v lockView
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &v))
+	assert.Equal(t, l.Fence(), v.Fence, "previous state")
+	assert.ErrorIs(t, l.NewDuration(time.Minute), locke.ErrNotOwner)
+
+	assert.EqualError(t, run("no\n", "steal", "-table", "Usuarios", "-value", "Pepe"), "error: aborted")
+	assert.NoError(t, run("", "steal", "-table", "Usuarios", "-value", "Pepe", "-type", "Admin", "-owner", "oncall", "-yes", "-json"))
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &v))
+	assert.Equal(t, "oncall", v.Owner)
+	assert.Equal(t, "Admin", v.LockType)
+	assert.True(t, v.Held)
+}
+
+func TestAdminPurge(t *testing.T) {
+	client, out, run := newTestCLI(t)
+	// Un lock que vencio hace una hora y que el TTL no ha borrado
+	acquire(t, client, "Ana", locke.WithClock(locketest.NewFakeClock(time.Now().Add(-time.Hour))))
+	acquire(t, client, "Pepe")
+
+	assert.EqualError(t, run("\n", "purge", "-table", "Usuarios"), "error: aborted")
+	assert.NoError(t, run("", "purge", "-table", "Usuarios", "-yes", "-json"))
+	var res struct {
+		Table  string `json:"table"`
+		Purged int    `json:"purged"`
+	}
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &res))
+	assert.Equal(t, "Usuarios", res.Table)
+	assert.Equal(t, 1, res.Purged)
+}
+
+func TestAdminUsage(t *testing.T) {
+	_, out, run := newTestCLI(t)
+	assert.EqualError(t, run("", "describe", "-table", "Usuarios"), "error: missing required flags")
+	assert.EqualError(t, run("", "purge"), "error: missing required flags")
+	assert.EqualError(t, run("", "unlock"), `error: unknown command "unlock"`)
+	assert.NoError(t, run("", "help"))
+	assert.Contains(t, out.String(), "force-release")
+}
@@ -0,0 +1,166 @@
+package locke
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Operaciones de administracion sobre LockTable, para incidentes: no respetan
+// al holder actual. El holder al que se le quita el lock se entera en su
+// siguiente NewDuration o Release (ErrNotOwner), y sus escrituras protegidas
+// con fence fallan con ErrStaleFence en cuanto alguien usa un fence mayor.
+
+// PurgeClient es lo que necesita Purge del cliente de DynamoDB.
+type PurgeClient interface {
+	Client
+	DeleteItemAPI
+}
+
+// ForceRelease libera table/lockValue sea quien sea el holder, escritor o
+// lectores, y devuelve el estado que tenia. Si no hay item devuelve
+// ErrLockNotFound. De opts solo cuenta WithTableName.
+func ForceRelease(ctx context.Context, svc Client, table, lockValue string, opts ...Option) (LockInfo, error) {
+	out, err := svc.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableOption(opts)),
+		Key: map[string]types.AttributeValue{
+			"tabla":     &types.AttributeValueMemberS{Value: table},
+			"lockvalue": &types.AttributeValueMemberS{Value: lockValue},
+		},
+		ConditionExpression: aws.String("attribute_exists(#tabla)"),
+		ExpressionAttributeNames: map[string]string{
+			"#tabla":         "tabla",
+			"#fence":         "fence",
+			"#readers":       "readers",
+			"#readerrelease": "readerrelease",
+			"#writerwaiting": "writerwaiting",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":zero": &types.AttributeValueMemberN{Value: "0"},
+		},
+		UpdateExpression: aws.String(
+			"SET #fence = :zero REMOVE #readers, #readerrelease, #writerwaiting",
+		),
+		ReturnValues: types.ReturnValueAllOld,
+	})
+	if err != nil {
+		var ccfe *types.ConditionalCheckFailedException
+		if errors.As(err, &ccfe) {
+			return LockInfo{}, ErrLockNotFound
+		}
+		return LockInfo{}, serviceError(err)
+	}
+	return lockInfo(out.Attributes), nil
+}
+
+// Steal adquiere table/lockValue en modo exclusivo aunque tenga holder, con un
+// fence nuevo y un lease de duration. opts son los de NewLock; el modo
+// compartido no se admite.
+func Steal(ctx context.Context, svc Client, table, lockValue, lockType string, duration time.Duration, opts ...Option) (Lock, error) {
+	li, err := NewLock("dynamo", svc, table, lockValue, lockType, duration, opts...)
+	if err != nil {
+		return nil, err
+	}
+	l := li.(*lock)
+	d := l.b.(*dynamolock)
+	if d.shared || d.versioned {
+		return nil, errors.New("error: shared or version lease locks can not be stolen")
+	}
+	var fence string
+	if !d.perLockFence {
+		f, err := d.nextFence(ctx, 1)
+		if err != nil {
+			return nil, err
+		}
+		fence = strconv.FormatInt(f, 10)
+	}
+	// La misma escritura que Acquire, sin condicion sobre el holder
+	in := d.acquireInput(l, l.clock.Now().UnixMilli(), fence)
+	in.ConditionExpression = nil
+	in.ReturnValuesOnConditionCheckFailure = ""
+	// DynamoDB rechaza nombres y valores que la expresion no usa
+	delete(in.ExpressionAttributeNames, "#tabla")
+	delete(in.ExpressionAttributeValues, ":zero")
+	delete(in.ExpressionAttributeValues, ":now")
+	delete(in.ExpressionAttributeValues, ":nowms")
+	in.ReturnValues = types.ReturnValueUpdatedNew
+	uio, err := d.svc.UpdateItem(ctx, in)
+	if err != nil {
+		return nil, serviceError(err)
+	}
+	l.fence = itemNumber(uio.Attributes, "fence")
+	l.holds = 1
+	return l, nil
+}
+
+// Purge borra los items de la particion table de LockTable cuyo lease vencio
+// y que el TTL aun no ha borrado, y devuelve cuantos borro. El borrado es
+// condicional, asi que no toca un lock que se adquiera mientras tanto. De
+// opts cuentan WithTableName y WithClock.
+func Purge(ctx context.Context, svc PurgeClient, table string, opts ...Option) (int, error) {
+	lockTable := tableOption(opts)
+	names := map[string]string{
+		"#readerrelease": "readerrelease",
+	}
+	values := map[string]types.AttributeValue{}
+	leaseTerms(names, values, optionsOf(opts).timeSource().Now().UnixMilli())
+	expired := leaseExpired + " AND " +
+		"(attribute_not_exists(#readerrelease) OR #readerrelease < :now)"
+	in := &dynamodb.QueryInput{
+		TableName:              aws.String(lockTable),
+		ConsistentRead:         aws.Bool(true),
+		KeyConditionExpression: aws.String("#tabla = :tabla"),
+		FilterExpression:       aws.String(expired),
+		ExpressionAttributeNames: map[string]string{
+			"#tabla":     "tabla",
+			"#lockvalue": "lockvalue",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":tabla": &types.AttributeValueMemberS{Value: table},
+		},
+		ProjectionExpression: aws.String("#tabla, #lockvalue"),
+	}
+	for k, v := range names {
+		in.ExpressionAttributeNames[k] = v
+	}
+	for k, v := range values {
+		in.ExpressionAttributeValues[k] = v
+	}
+	n := 0
+	for {
+		out, err := svc.Query(ctx, in)
+		if err != nil {
+			return n, serviceError(err)
+		}
+		for _, item := range out.Items {
+			_, err := svc.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+				TableName: aws.String(lockTable),
+				Key: map[string]types.AttributeValue{
+					"tabla":     item["tabla"],
+					"lockvalue": item["lockvalue"],
+				},
+				ConditionExpression:       aws.String(expired),
+				ExpressionAttributeNames:  names,
+				ExpressionAttributeValues: values,
+			})
+			var ccfe *types.ConditionalCheckFailedException
+			if errors.As(err, &ccfe) {
+				// Lo adquirio alguien despues del Query
+				continue
+			}
+			if err != nil {
+				return n, serviceError(err)
+			}
+			n++
+		}
+		if len(out.LastEvaluatedKey) == 0 {
+			return n, nil
+		}
+		in.ExclusiveStartKey = out.LastEvaluatedKey
+	}
+}
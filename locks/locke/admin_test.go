@@ -0,0 +1,65 @@
+package locke
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"dynamodb/locks/locke/locketest"
+)
+
+func TestAdmin(t *testing.T) {
+	ctx := context.Background()
+	svc := newFakeDynamo(t)
+	// Un lock que vencio hace una hora y que el TTL no ha borrado
+	old := locketest.NewFakeClock(time.Now().Add(-time.Hour))
+	acquired(t, dynamoLock(t, svc, "Ana", "Lock1", time.Minute, WithClock(old)))
+	live := acquired(t, dynamoLock(t, svc, "Pepe", "Lock1", time.Minute))
+
+	// Con el reloj de hace una hora Ana sigue vigente
+	if n, err := Purge(ctx, svc, "Usuarios", WithClock(old)); err != nil || n != 0 {
+		t.Fatalf("Purge an hour ago: got %d %v, want 0", n, err)
+	}
+	n, err := Purge(ctx, svc, "Usuarios")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("Purge deleted %d, want 1", n)
+	}
+	if info, err := Describe(ctx, svc, "Usuarios", "Ana"); err != nil || info.Fence != "" {
+		t.Fatalf("purged lock: got %+v %v", info, err)
+	}
+
+	info, err := ForceRelease(ctx, svc, "Usuarios", "Pepe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Fence != live.Fence() {
+		t.Fatalf("ForceRelease returned fence %s, want %s", info.Fence, live.Fence())
+	}
+	if err := live.NewDuration(time.Minute); !errors.Is(err, ErrNotOwner) {
+		t.Fatalf("NewDuration after ForceRelease: got %v, want ErrNotOwner", err)
+	}
+	if _, err := ForceRelease(ctx, svc, "Usuarios", "Nadie"); !errors.Is(err, ErrLockNotFound) {
+		t.Fatalf("ForceRelease of missing lock: got %v, want ErrLockNotFound", err)
+	}
+
+	if err := live.Acquire(); err != nil {
+		t.Fatal(err)
+	}
+	stolen, err := Steal(ctx, svc, "Usuarios", "Pepe", "Lock1", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fenceNumber(t, stolen) <= fenceNumber(t, live) {
+		t.Fatalf("stolen fence %s not above %s", stolen.Fence(), live.Fence())
+	}
+	if err := live.Release(); !errors.Is(err, ErrNotOwner) {
+		t.Fatalf("Release after Steal: got %v, want ErrNotOwner", err)
+	}
+	if err := stolen.Release(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,73 @@
+package locke
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Backoff decide cuanto esperar entre intentos de AcquireWait. attempt empieza
+// en 0 y prev es la espera devuelta en el intento anterior (0 la primera vez).
+// AcquireWait espera al menos minBackoff aunque Next devuelva menos.
+type Backoff interface {
+	Next(attempt int, prev time.Duration) time.Duration
+}
+
+// minBackoff es la espera minima entre intentos de AcquireWait, para no
+// reintentar sin pausa contra el backend con un Base o Delay 0 o negativo.
+const minBackoff = 10 * time.Millisecond
+
+// FixedBackoff espera siempre Delay.
+type FixedBackoff struct {
+	Delay time.Duration
+}
+
+func (b FixedBackoff) Next(attempt int, prev time.Duration) time.Duration {
+	return b.Delay
+}
+
+// ExponentialBackoff espera Base*2^attempt, limitado a Max si es > 0. Con Jitter la
+// espera es aleatoria entre 0 y ese valor ("full jitter").
+type ExponentialBackoff struct {
+	Base   time.Duration
+	Max    time.Duration
+	Jitter bool
+}
+
+func (b ExponentialBackoff) Next(attempt int, prev time.Duration) time.Duration {
+	d := b.Base
+	for i := 0; i < attempt && d < math.MaxInt64/2 && (b.Max <= 0 || d < b.Max); i++ {
+		d *= 2
+	}
+	if b.Max > 0 && d > b.Max {
+		d = b.Max
+	}
+	if b.Jitter && d > 0 {
+		d = time.Duration(rand.Int63n(int64(d) + 1))
+	}
+	return d
+}
+
+// DecorrelatedJitterBackoff espera un valor aleatorio entre Base y 3 veces la
+// espera anterior, limitado a Max si es > 0.
+type DecorrelatedJitterBackoff struct {
+	Base time.Duration
+	Max  time.Duration
+}
+
+func (b DecorrelatedJitterBackoff) Next(attempt int, prev time.Duration) time.Duration {
+	if prev < b.Base {
+		prev = b.Base
+	}
+	d := b.Base
+	if upper := 3 * prev; upper > b.Base {
+		d += time.Duration(rand.Int63n(int64(upper - b.Base)))
+	}
+	if b.Max > 0 && d > b.Max {
+		d = b.Max
+	}
+	return d
+}
+
+// DefaultBackoff se usa cuando AcquireWait recibe un Backoff nil.
+var DefaultBackoff Backoff = DecorrelatedJitterBackoff{Base: 100 * time.Millisecond, Max: 5 * time.Second}
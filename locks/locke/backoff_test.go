@@ -0,0 +1,87 @@
+package locke
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestExponentialBackoff(t *testing.T) {
+	b := ExponentialBackoff{Base: 10 * time.Millisecond, Max: 80 * time.Millisecond}
+	want := []time.Duration{10, 20, 40, 80, 80, 80}
+	for attempt, w := range want {
+		if got := b.Next(attempt, 0); got != w*time.Millisecond {
+			t.Fatalf("attempt %d: got %v, want %v", attempt, got, w*time.Millisecond)
+		}
+	}
+	// Sin Max no se desborda
+	b.Max = 0
+	if got := b.Next(1000, 0); got <= 0 {
+		t.Fatalf("attempt 1000 without Max: got %v", got)
+	}
+	// Con Jitter, entre 0 y la espera sin jitter
+	b = ExponentialBackoff{Base: 10 * time.Millisecond, Max: 80 * time.Millisecond, Jitter: true}
+	for attempt := 0; attempt < 100; attempt++ {
+		limit := ExponentialBackoff{Base: b.Base, Max: b.Max}.Next(attempt, 0)
+		if got := b.Next(attempt, 0); got < 0 || got > limit {
+			t.Fatalf("attempt %d with jitter: got %v, want between 0 and %v", attempt, got, limit)
+		}
+	}
+}
+
+func TestDecorrelatedJitterBackoff(t *testing.T) {
+	b := DecorrelatedJitterBackoff{Base: 10 * time.Millisecond, Max: time.Second}
+	var prev time.Duration
+	for attempt := 0; attempt < 100; attempt++ {
+		// Entre Base y 3 veces la anterior, o Base la primera vez
+		upper := 3 * prev
+		if upper < 3*b.Base {
+			upper = 3 * b.Base
+		}
+		if upper > b.Max {
+			upper = b.Max
+		}
+		got := b.Next(attempt, prev)
+		if got < b.Base || got > upper {
+			t.Fatalf("attempt %d after %v: got %v, want between %v and %v", attempt, prev, got, b.Base, upper)
+		}
+		prev = got
+	}
+	// Max limita aunque la anterior sea mayor
+	if got := b.Next(0, time.Hour); got > b.Max {
+		t.Fatalf("after an hour: got %v, want at most %v", got, b.Max)
+	}
+	// Sin Max crece con la anterior
+	b.Max = 0
+	if got := b.Next(0, time.Hour); got < b.Base || got > 3*time.Hour {
+		t.Fatalf("after an hour without Max: got %v", got)
+	}
+}
+
+func TestFixedBackoff(t *testing.T) {
+	b := FixedBackoff{Delay: 50 * time.Millisecond}
+	for attempt := 0; attempt < 3; attempt++ {
+		if got := b.Next(attempt, time.Second); got != b.Delay {
+			t.Fatalf("attempt %d: got %v, want %v", attempt, got, b.Delay)
+		}
+	}
+}
+
+func TestMinBackoff(t *testing.T) {
+	for _, delay := range []time.Duration{0, -time.Second} {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		attempts := 0
+		err := retryHeld(ctx, systemClock{}, FixedBackoff{Delay: delay}, func(ctx context.Context) error {
+			attempts++
+			return &HeldError{}
+		})
+		cancel()
+		if err != context.DeadlineExceeded {
+			t.Fatalf("delay %v: got %v, want context.DeadlineExceeded", delay, err)
+		}
+		// Como mucho un intento cada minBackoff
+		if max := int(100*time.Millisecond/minBackoff) + 1; attempts > max {
+			t.Fatalf("delay %v: %d attempts in 100ms, want at most %d", delay, attempts, max)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package locke
+
+import "time"
+
+// Clock es la fuente de tiempo de un lock: los leases, los vencimientos y las
+// esperas de AcquireWait. Por defecto es el reloj del sistema; WithClock la
+// cambia, por ejemplo por locketest.FakeClock en tests.
+type Clock interface {
+	Now() time.Time
+	// After es como time.After.
+	After(d time.Duration) <-chan time.Time
+}
+
+// systemClock es el reloj del sistema.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// WithClock hace que el lock use c en lugar del reloj del sistema. Con el
+// backend "dynamo" solo cambia las decisiones locales: las condiciones se
+// evaluan con los instantes de c, pero el TTL de DynamoDB sigue el reloj real.
+func WithClock(c Clock) Option {
+	return func(o *options) {
+		o.clock = c
+	}
+}
+
+// timeSource es el reloj elegido con WithClock, o el del sistema.
+func (o options) timeSource() Clock {
+	if o.clock == nil {
+		return systemClock{}
+	}
+	return o.clock
+}
+
+// clockOf es el reloj de l, o el del sistema si l no es un lock de NewLock.
+func clockOf(l Lock) Clock {
+	if lo, ok := asLock(l); ok {
+		return lo.clock
+	}
+	return systemClock{}
+}
@@ -0,0 +1,680 @@
+package locke
+
+import (
+	"context"
+	"dynamodb/fakedynamo"
+	"dynamodb/locks/locke/locketest"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// newLockFunc crea locks sobre un mismo store del backend bajo prueba.
+type newLockFunc func(t *testing.T, lockType string, duration time.Duration, opts ...Option) Lock
+
+// backends devuelve, por backend, una funcion que crea locks sobre un store
+// nuevo para cada test.
+func backends() map[string]func(t *testing.T) newLockFunc {
+	return map[string]func(t *testing.T) newLockFunc{
+		"memory": func(t *testing.T) newLockFunc {
+			store := NewMemoryStore()
+			return func(t *testing.T, lockType string, duration time.Duration, opts ...Option) Lock {
+				l, err := NewLock("memory", store, "Usuarios", "Pepe", lockType, duration, opts...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return l
+			}
+		},
+		"dynamo":         dynamoBackend(),
+		"dynamo-perlock": dynamoBackend(WithPerLockFence()),
+		"file": func(t *testing.T) newLockFunc {
+			dir := t.TempDir()
+			return func(t *testing.T, lockType string, duration time.Duration, opts ...Option) Lock {
+				l, err := NewLock("file", dir, "Usuarios", "Pepe", lockType, duration, opts...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return l
+			}
+		},
+	}
+}
+
+// dynamoBackend crea locks "dynamo" con base opts sobre un fakedynamo nuevo
+// para cada test.
+func dynamoBackend(base ...Option) func(t *testing.T) newLockFunc {
+	return func(t *testing.T) newLockFunc {
+		svc := newFakeDynamo(t)
+		return func(t *testing.T, lockType string, duration time.Duration, opts ...Option) Lock {
+			return dynamoLock(t, svc, "Pepe", lockType, duration, append(append([]Option{}, base...), opts...)...)
+		}
+	}
+}
+
+// dynamoLock crea un lock "dynamo" de Usuarios/lockValue sobre svc.
+func dynamoLock(t *testing.T, svc Client, lockValue, lockType string, duration time.Duration, opts ...Option) Lock {
+	t.Helper()
+	l, err := NewLock("dynamo", svc, "Usuarios", lockValue, lockType, duration, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+// advanceUntilDone adelanta clock de step en step, cada vez que la espera bajo
+// prueba esta bloqueada en el reloj, hasta que done devuelve su resultado.
+// before se llama antes de cada paso con el tiempo adelantado hasta entonces.
+func advanceUntilDone(t *testing.T, clock *locketest.FakeClock, step, max time.Duration, done <-chan error, before func(elapsed time.Duration)) error {
+	t.Helper()
+	for elapsed := time.Duration(0); ; elapsed += step {
+		for clock.Pending() == 0 {
+			select {
+			case err := <-done:
+				return err
+			default:
+				time.Sleep(time.Millisecond)
+			}
+		}
+		if elapsed > max {
+			t.Fatalf("still waiting after %v", elapsed)
+		}
+		if before != nil {
+			before(elapsed)
+		}
+		clock.Advance(step)
+	}
+}
+
+// acquired adquiere l, o termina el test si no puede.
+func acquired(t *testing.T, l Lock) Lock {
+	t.Helper()
+	if err := l.Acquire(); err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+// newFakeDynamo arranca un fakedynamo con la tabla de locks DefaultTable.
+func newFakeDynamo(t *testing.T) *dynamodb.Client {
+	t.Helper()
+	s := fakedynamo.New()
+	t.Cleanup(s.Close)
+	svc := s.Client()
+	if err := EnsureTable(context.Background(), svc, DefaultTable); err != nil {
+		t.Fatal(err)
+	}
+	return svc
+}
+
+// renewFailingClient hace fallar las renovaciones de lease (NewDuration) con
+// el error de failRenewals; el resto de operaciones llegan a Client.
+type renewFailingClient struct {
+	Client
+	mu  sync.Mutex
+	err error
+}
+
+func (c *renewFailingClient) failRenewals(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.err = err
+}
+
+func (c *renewFailingClient) UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	c.mu.Lock()
+	err := c.err
+	c.mu.Unlock()
+	if err != nil && strings.Contains(aws.ToString(params.UpdateExpression), ":nrt") {
+		return nil, err
+	}
+	return c.Client.UpdateItem(ctx, params, optFns...)
+}
+
+func TestConformance(t *testing.T) {
+	tests := map[string]func(t *testing.T, newLock newLockFunc){
+		"AcquireRelease": testAcquireRelease,
+		"Held":           testHeld,
+		"FenceIncreases": testFenceIncreases,
+		"NewDuration":    testNewDuration,
+		"Expiry":         testExpiry,
+		"AcquireWait":    testAcquireWait,
+		"WaitMargin":     testWaitMargin,
+		"Reentrant":      testReentrant,
+		"Concurrent":     testConcurrent,
+	}
+	for name, backend := range backends() {
+		backend := backend
+		t.Run(name, func(t *testing.T) {
+			for name, test := range tests {
+				test := test
+				t.Run(name, func(t *testing.T) {
+					t.Parallel()
+					test(t, backend(t))
+				})
+			}
+		})
+	}
+}
+
+func fenceNumber(t *testing.T, l Lock) int64 {
+	t.Helper()
+	n, err := strconv.ParseInt(l.Fence(), 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func testAcquireRelease(t *testing.T, newLock newLockFunc) {
+	l := newLock(t, "Lock1", time.Minute)
+	if err := l.Release(); !errors.Is(err, ErrNotAcquired) {
+		t.Fatalf("Release before Acquire: got %v, want ErrNotAcquired", err)
+	}
+	if err := l.Acquire(); err != nil {
+		t.Fatal(err)
+	}
+	if fenceNumber(t, l) <= 0 {
+		t.Fatalf("fence %s, want > 0", l.Fence())
+	}
+	if l.RemainingDuration() <= 0 {
+		t.Fatal("no remaining duration on acquired lock")
+	}
+	if err := l.Acquire(); !errors.Is(err, ErrAlreadyHeld) {
+		t.Fatalf("second Acquire: got %v, want ErrAlreadyHeld", err)
+	}
+	if err := l.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if l.Fence() != "0" || l.RemainingDuration() != ZeroDuration {
+		t.Fatalf("released lock has fence %s", l.Fence())
+	}
+	if err := l.Release(); !errors.Is(err, ErrNotAcquired) {
+		t.Fatalf("second Release: got %v, want ErrNotAcquired", err)
+	}
+}
+
+func testHeld(t *testing.T, newLock newLockFunc) {
+	l1 := newLock(t, "Lock1", time.Minute)
+	l2 := newLock(t, "Lock2", time.Minute)
+	if err := l1.Acquire(); err != nil {
+		t.Fatal(err)
+	}
+	err := l2.Acquire()
+	var held *HeldError
+	if !errors.Is(err, ErrHeld) || !errors.As(err, &held) {
+		t.Fatalf("got %v, want *HeldError", err)
+	}
+	if held.LockName != "Pepe->Lock1" || held.LockType != "Lock1" || held.Fence != l1.Fence() {
+		t.Fatalf("wrong holder %+v", held)
+	}
+	if held.ReleaseTime.Before(time.Now().Add(50 * time.Second)) {
+		t.Fatalf("wrong holder release time %v", held.ReleaseTime)
+	}
+	if l2.Fence() != "0" {
+		t.Fatal("failed Acquire left a fence")
+	}
+	if err := l1.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if err := l2.Acquire(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testFenceIncreases(t *testing.T, newLock newLockFunc) {
+	var last int64
+	for i := 0; i < 3; i++ {
+		l := newLock(t, "Lock"+strconv.Itoa(i), time.Minute)
+		if err := l.Acquire(); err != nil {
+			t.Fatal(err)
+		}
+		fence := fenceNumber(t, l)
+		if fence <= last {
+			t.Fatalf("fence %d not greater than previous %d", fence, last)
+		}
+		last = fence
+		if err := l.Release(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func testNewDuration(t *testing.T, newLock newLockFunc) {
+	l := newLock(t, "Lock1", 10*time.Second)
+	if err := l.NewDuration(time.Minute); !errors.Is(err, ErrNotAcquired) {
+		t.Fatalf("got %v, want ErrNotAcquired", err)
+	}
+	if err := l.Acquire(); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.NewDuration(time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if l.RemainingDuration() < 50*time.Second {
+		t.Fatalf("remaining %v after NewDuration", l.RemainingDuration())
+	}
+	if err := l.Release(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testExpiry(t *testing.T, newLock newLockFunc) {
+	// Los leases tienen precision de milisegundos
+	clock := locketest.NewFakeClock(time.Now())
+	l1 := newLock(t, "Lock1", 1500*time.Millisecond, WithClock(clock))
+	if err := l1.Acquire(); err != nil {
+		t.Fatal(err)
+	}
+	if d := l1.RemainingDuration(); d != 1500*time.Millisecond {
+		t.Fatalf("RemainingDuration of a 1.5s lease: %v", d)
+	}
+	clock.Advance(1501 * time.Millisecond)
+	if l1.Fence() != "0" {
+		t.Fatalf("expired lock has fence %s", l1.Fence())
+	}
+	if err := l1.Release(); !errors.Is(err, ErrNotAcquired) && !errors.Is(err, ErrExpired) {
+		t.Fatalf("Release of expired lock: got %v", err)
+	}
+	if err := l1.Acquire(); !errors.Is(err, ErrExpired) {
+		t.Fatalf("Acquire of expired lock: got %v, want ErrExpired", err)
+	}
+	l2 := newLock(t, "Lock2", time.Minute, WithClock(clock))
+	if err := l2.Acquire(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testAcquireWait(t *testing.T, newLock newLockFunc) {
+	clock := locketest.NewFakeClock(time.Now())
+	l1 := newLock(t, "Lock1", time.Second, WithClock(clock))
+	l2 := newLock(t, "Lock2", time.Minute, WithClock(clock))
+	if err := l1.Acquire(); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- l2.AcquireWait(ctx, FixedBackoff{Delay: 50 * time.Millisecond}) }()
+	clock.BlockUntil(1)
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+	go func() { done <- l2.AcquireWait(context.Background(), FixedBackoff{Delay: 100 * time.Millisecond}) }()
+	if err := advanceUntilDone(t, clock, 100*time.Millisecond, 2*time.Second, done, nil); err != nil {
+		t.Fatal(err)
+	}
+	if fenceNumber(t, l2) <= 0 {
+		t.Fatal("AcquireWait returned without fence")
+	}
+}
+
+// testWaitMargin comprueba que AcquireWait no espera mas de lo que le queda
+// al holder aunque el backoff sea largo.
+func testWaitMargin(t *testing.T, newLock newLockFunc) {
+	clock := locketest.NewFakeClock(time.Now())
+	l1 := newLock(t, "Lock1", 300*time.Millisecond, WithClock(clock))
+	l2 := newLock(t, "Lock2", time.Minute, WithClock(clock))
+	if err := l1.Acquire(); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- l2.AcquireWait(context.Background(), FixedBackoff{Delay: time.Minute}) }()
+	// Vuelve a intentarlo un milisegundo despues de que venza el holder
+	clock.BlockUntil(1)
+	clock.Advance(301 * time.Millisecond)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("AcquireWait still waiting after the 300ms lease")
+	}
+}
+
+func testReentrant(t *testing.T, newLock newLockFunc) {
+	l1 := newLock(t, "Lock1", time.Minute, WithReentrant())
+	l2 := newLock(t, "Lock2", time.Minute)
+	for i := 0; i < 3; i++ {
+		if err := l1.Acquire(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fence := l1.Fence()
+	for i := 0; i < 2; i++ {
+		if err := l1.Release(); err != nil {
+			t.Fatal(err)
+		}
+		if l1.Fence() != fence {
+			t.Fatalf("nested Release changed fence to %s", l1.Fence())
+		}
+		if err := l2.Acquire(); !errors.Is(err, ErrHeld) {
+			t.Fatalf("got %v, want ErrHeld while outer holder keeps the lock", err)
+		}
+	}
+	if err := l1.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if err := l2.Acquire(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewLockContext(t *testing.T) {
+	store := NewMemoryStore()
+	if _, err := NewLockContext(context.Background(), "memory", store, "Usuarios", "Pepe", "Lock1"); !errors.Is(err, ErrNoDeadline) {
+		t.Fatalf("got %v, want ErrNoDeadline", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	l1, err := NewLockContext(ctx, "memory", store, "Usuarios", "Pepe", "Lock1", WithDeadlineMargin(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l1.Acquire(); err != nil {
+		t.Fatal(err)
+	}
+	if d := l1.RemainingDuration(); d <= time.Minute || d > time.Minute+time.Second {
+		t.Fatalf("lease %v, want deadline plus margin", d)
+	}
+	// El lease se mide con el reloj del lock, no con el de pared
+	clock := locketest.NewFakeClock(time.Now().Add(time.Hour))
+	dctx, dcancel := context.WithDeadline(context.Background(), clock.Now().Add(time.Minute))
+	defer dcancel()
+	l3, err := NewLockContext(dctx, "memory", store, "Usuarios", "Juan", "Lock3", WithDeadlineMargin(time.Second), WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l3.Acquire(); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(30 * time.Second)
+	if d := l3.RemainingDuration(); d != 31*time.Second {
+		t.Fatalf("lease %v, want 31s", d)
+	}
+	// Al cancelar el contexto se libera
+	cancel()
+	l2, err := NewLock("memory", store, "Usuarios", "Pepe", "Lock2", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wctx, wcancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer wcancel()
+	if err := l2.AcquireWait(wctx, FixedBackoff{Delay: 10 * time.Millisecond}); err != nil {
+		t.Fatalf("lock not released after cancel: %v", err)
+	}
+}
+
+func TestWithLock(t *testing.T) {
+	store := NewMemoryStore()
+	opts := LockOptions{
+		SvcType:   "memory",
+		Svc:       store,
+		Table:     "Usuarios",
+		LockValue: "Pepe",
+		LockType:  "Lock1",
+		Duration:  time.Minute,
+	}
+	fnErr := errors.New("fn failed")
+	err := WithLock(context.Background(), opts, func(ctx context.Context, fence string) error {
+		if fence == "0" {
+			t.Errorf("fn called without the lock")
+		}
+		l2, err := NewLock("memory", store, "Usuarios", "Pepe", "Lock2", time.Minute)
+		if err != nil {
+			return err
+		}
+		if err := l2.Acquire(); !errors.Is(err, ErrHeld) {
+			t.Errorf("got %v, want ErrHeld while fn runs", err)
+		}
+		return fnErr
+	})
+	if !errors.Is(err, fnErr) {
+		t.Fatalf("got %v, want the fn error", err)
+	}
+	// Liberado al terminar fn
+	l3, err := NewLock("memory", store, "Usuarios", "Pepe", "Lock3", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l3.Acquire(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWithLockLongWait(t *testing.T) {
+	store := NewMemoryStore()
+	clock := locketest.NewFakeClock(time.Now())
+	holder, err := NewLock("memory", store, "Usuarios", "Pepe", "Lock1", time.Hour, WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	acquired(t, holder)
+	opts := LockOptions{
+		SvcType:   "memory",
+		Svc:       store,
+		Table:     "Usuarios",
+		LockValue: "Pepe",
+		LockType:  "Lock2",
+		Duration:  10 * time.Second,
+		Options:   []Option{WithClock(clock)},
+		Backoff:   FixedBackoff{Delay: 5 * time.Second},
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- WithLock(context.Background(), opts, func(ctx context.Context, fence string) error {
+			if fence == "0" {
+				t.Errorf("fn called without the lock")
+			}
+			return nil
+		})
+	}()
+	// Espera mas que Duration antes de que el holder libere
+	for elapsed := time.Duration(0); elapsed < 30*time.Second; elapsed += 5 * time.Second {
+		clock.BlockUntil(1)
+		clock.Advance(5 * time.Second)
+	}
+	if err := holder.Release(); err != nil {
+		t.Fatal(err)
+	}
+	clock.BlockUntil(1)
+	clock.Advance(5 * time.Second)
+	if err := <-done; err != nil {
+		t.Fatalf("WithLock after a wait longer than Duration: %v", err)
+	}
+}
+
+func TestWithLockRenewFails(t *testing.T) {
+	svc := &renewFailingClient{Client: newFakeDynamo(t)}
+	boom := errors.New("boom")
+	svc.failRenewals(boom)
+	opts := LockOptions{
+		SvcType:   "dynamo",
+		Svc:       svc,
+		Table:     "Usuarios",
+		LockValue: "Pepe",
+		LockType:  "Lock1",
+		Duration:  time.Minute,
+	}
+	err := WithLock(context.Background(), opts, func(ctx context.Context, fence string) error {
+		t.Error("fn called without a renewed lease")
+		return nil
+	})
+	if !errors.Is(err, boom) {
+		t.Fatalf("got %v, want the renewal error", err)
+	}
+	// No se queda con el lock
+	acquired(t, dynamoLock(t, svc.Client, "Pepe", "Lock2", time.Minute))
+}
+
+func TestLockManager(t *testing.T) {
+	store := NewMemoryStore()
+	clock := locketest.NewFakeClock(time.Now())
+	m := NewLockManager("memory", store, 10*time.Millisecond, WithClock(clock))
+	var locks []Lock
+	for _, value := range []string{"Pepe", "Juan"} {
+		l, err := m.NewLock("Usuarios", value, "Lock1", 200*time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := l.Acquire(); err != nil {
+			t.Fatal(err)
+		}
+		locks = append(locks, l)
+	}
+	// Pasado el lease inicial los renueva el manager
+	for elapsed := time.Duration(0); elapsed < 500*time.Millisecond; elapsed += 10 * time.Millisecond {
+		clock.BlockUntil(1)
+		clock.Advance(10 * time.Millisecond)
+	}
+	clock.BlockUntil(1)
+	for _, l := range locks {
+		if l.Fence() == "0" {
+			t.Fatal("managed lock not renewed")
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := m.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if m.Len() != 0 {
+		t.Fatalf("%d locks left after Close", m.Len())
+	}
+	for _, value := range []string{"Pepe", "Juan"} {
+		l, err := NewLock("memory", store, "Usuarios", value, "Lock2", time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := l.Acquire(); err != nil {
+			t.Fatalf("%s not released by Close: %v", value, err)
+		}
+	}
+	if _, err := m.NewLock("Usuarios", "Pepe", "Lock3", time.Minute); err == nil {
+		t.Fatal("closed manager created a lock")
+	}
+}
+
+func TestLockManagerReacquire(t *testing.T) {
+	store := NewMemoryStore()
+	m := NewLockManager("memory", store, 0)
+	l, err := m.NewLock("Usuarios", "Pepe", "Lock1", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Acquire(); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if m.Len() != 0 {
+		t.Fatalf("%d locks registered after Release", m.Len())
+	}
+	// Adquirido otra vez vuelve al registro, y Close lo libera
+	if err := l.AcquireWait(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if m.Len() != 1 {
+		t.Fatalf("%d locks registered after acquiring again, want 1", m.Len())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := m.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewLock("memory", store, "Usuarios", "Pepe", "Lock2", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Acquire(); err != nil {
+		t.Fatalf("not released by Close: %v", err)
+	}
+	if err := other.Release(); err != nil {
+		t.Fatal(err)
+	}
+	// Con el manager cerrado no se queda con el lock
+	if err := l.Acquire(); err == nil {
+		t.Fatal("closed manager kept a lock acquired again")
+	}
+	if l.Fence() != "0" {
+		t.Fatalf("fence %s after Acquire on a closed manager", l.Fence())
+	}
+}
+
+func TestLockManagerClock(t *testing.T) {
+	clock := locketest.NewFakeClock(time.Now())
+	m := NewLockManager("memory", NewMemoryStore(), 10*time.Second, WithClock(clock))
+	defer m.Close(context.Background())
+	l, err := m.NewLock("Usuarios", "Pepe", "Lock1", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Acquire(); err != nil {
+		t.Fatal(err)
+	}
+	// Renueva cuando le queda menos de la mitad segun el reloj del manager
+	clock.BlockUntil(1)
+	clock.Advance(20 * time.Second)
+	clock.BlockUntil(1)
+	if d := l.RemainingDuration(); d != 40*time.Second {
+		t.Fatalf("lease %v after 20s, want 40s without renewal", d)
+	}
+	clock.Advance(11 * time.Second)
+	clock.BlockUntil(1)
+	if d := l.RemainingDuration(); d != time.Minute {
+		t.Fatalf("lease %v after the manager renewal, want 1m", d)
+	}
+}
+
+// testConcurrent usa un mismo handle desde varias goroutines; con -race
+// comprueba que el estado del handle esta protegido.
+func testConcurrent(t *testing.T, newLock newLockFunc) {
+	l := newLock(t, "Lock1", time.Minute)
+	const n = 8
+	var wg sync.WaitGroup
+	var acquired, released int32
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := l.Acquire()
+			switch {
+			case err == nil:
+				atomic.AddInt32(&acquired, 1)
+			case !errors.Is(err, ErrAlreadyHeld):
+				t.Errorf("Acquire: %v", err)
+			}
+			l.Fence()
+			l.RemainingDuration()
+			if err := l.NewDuration(time.Minute); err != nil {
+				t.Errorf("NewDuration: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := l.Release()
+			switch {
+			case err == nil:
+				atomic.AddInt32(&released, 1)
+			case !errors.Is(err, ErrNotAcquired):
+				t.Errorf("Release: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if acquired != 1 || released != 1 {
+		t.Fatalf("acquired %d and released %d times, want 1 and 1", acquired, released)
+	}
+}
@@ -0,0 +1,60 @@
+package locke
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// DefaultDeadlineMargin es el margen que NewLockContext suma al tiempo que
+// queda hasta ctx.Deadline() si no se indica otro con WithDeadlineMargin.
+const DefaultDeadlineMargin = 2 * time.Second
+
+// releaseTimeout limita el Release best effort al cancelarse el contexto.
+const releaseTimeout = 5 * time.Second
+
+// ErrNoDeadline: NewLockContext con un contexto sin deadline.
+var ErrNoDeadline = errors.New("error: context has no deadline")
+
+// WithDeadlineMargin cambia el margen que NewLockContext suma al deadline del
+// contexto.
+func WithDeadlineMargin(margin time.Duration) Option {
+	return func(o *options) {
+		o.deadlineMargin = margin
+	}
+}
+
+// NewLockContext es NewLock con el lease sacado del contexto, pensado para
+// Lambda: dura lo que queda hasta ctx.Deadline() mas un margen
+// (DefaultDeadlineMargin o WithDeadlineMargin), medido con el reloj del lock
+// (WithClock). Cuando ctx se cancela o llega su deadline, libera el lock si lo tiene, best effort: si falla, el lease
+// vence solo. Devuelve ErrNoDeadline si ctx no tiene deadline.
+func NewLockContext(ctx context.Context, svcType string, svc interface{}, table, lockValue, lockType string, opts ...Option) (Lock, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return nil, ErrNoDeadline
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	o := options{deadlineMargin: DefaultDeadlineMargin}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	l, err := NewLock(svcType, svc, table, lockValue, lockType, deadline.Sub(o.timeSource().Now())+o.deadlineMargin, opts...)
+	if err != nil {
+		return nil, err
+	}
+	context.AfterFunc(ctx, func() {
+		rctx, cancel := context.WithTimeout(context.Background(), releaseTimeout)
+		defer cancel()
+		// Tambien los Acquire anidados de un lock reentrante; ErrNotAcquired
+		// si no llego a adquirirlo o ya lo libero
+		for l.Fence() != "0" {
+			if err := l.ReleaseContext(rctx); err != nil {
+				return
+			}
+		}
+	})
+	return l, nil
+}
@@ -1,231 +1,395 @@
 package locke
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"strconv"
-	"strings"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 )
 
 const (
 	UploadTransaction = "UploadTransaction"
-	lockTable         = "LockTable"
+	// DefaultTable es la tabla de locks si no se indica otra con
+	// WithTableName.
+	DefaultTable = "LockTable"
 )
 
+// Los leases se guardan con precision de milisegundos en releasetimems y
+// startingtimems. releasetime sigue en segundos, redondeado hacia arriba,
+// porque es el atributo del TTL, y startingTime en segundos por
+// compatibilidad. Los items sin releasetimems, escritos por versiones
+// anteriores, se comparan en segundos. En modo compartido readerrelease sigue
+// en segundos.
+const (
+	leaseExpired = "((attribute_not_exists(#releasetimems) AND #releasetime < :now) OR #releasetimems < :nowms)"
+	leaseLive    = "((attribute_not_exists(#releasetimems) AND #releasetime > :now) OR #releasetimems > :nowms)"
+)
+
+// leaseTerms anade a names/values lo que usan leaseExpired y leaseLive; now
+// en milisegundos Unix.
+func leaseTerms(names map[string]string, values map[string]types.AttributeValue, now int64) {
+	names["#releasetime"] = "releasetime"
+	names["#releasetimems"] = "releasetimems"
+	values[":now"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(now/1000, 10)}
+	values[":nowms"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(now, 10)}
+}
+
+// ceilSeconds pasa milisegundos Unix a segundos redondeando hacia arriba, para
+// que el TTL no borre un lease antes de que venza.
+func ceilSeconds(ms int64) int64 {
+	return (ms + 999) / 1000
+}
+
+// Client son las operaciones de DynamoDB que usa locke. *dynamodb.Client la
+// implementa; se puede envolver o sustituir, por ejemplo en tests.
+type Client interface {
+	UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
+	Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error)
+}
+
+// dynamolock guarda los locks en la tabla lockTable de DynamoDB.
 type dynamolock struct {
-	lock
-	svc *dynamodb.DynamoDB
+	svc          Client
+	lockTable    string
+	perLockFence bool
+	shared       bool
+	writerWait   time.Duration
+	versioned    bool
+	// rvn es la version que escribio este handle y seen la del holder que
+	// observa mientras espera (WithVersionLease)
+	rvn  string
+	seen versionSeen
 }
 
-func newDynamoLock(svc *dynamodb.DynamoDB, table, lockValue, lockType string, duration time.Duration) (Lock, error) {
-	now := time.Now().UTC()
+func newDynamoLock(svc Client, o options) (backend, error) {
 	return &dynamolock{
-		svc: svc,
-		lock: lock{
-			fence:        "0",
-			table:        table,
-			lockValue:    lockValue,
-			lockType:     lockType,
-			lockName:     strings.Join([]string{lockValue, lockType}, "->"),
-			startingTime: now.Unix(),
-			releaseTime:  now.Add(duration).Unix(),
-		},
+		svc:          svc,
+		lockTable:    o.tableName(),
+		perLockFence: o.perLockFence,
+		shared:       o.mode == Shared,
+		writerWait:   o.writerWait,
+		versioned:    o.versioned,
 	}, nil
 }
 
-func (l *dynamolock) Acquire() error {
-	now := time.Now().UTC().Unix()
-	// Ya vencio el lock
-	if l.releaseTime <= now {
-		l.fence = "0"
-		return errors.New("error: not creating an expired lock")
+func (d *dynamolock) acquire(ctx context.Context, l *lock, now int64) (string, error) {
+	if d.shared {
+		return d.acquireShared(ctx, l, now)
+	}
+	if d.versioned {
+		return d.acquireVersioned(ctx, l, now)
+	}
+	var fence string
+	if !d.perLockFence {
+		f, err := d.nextFence(ctx, 1)
+		if err != nil {
+			return "", err
+		}
+		fence = strconv.FormatInt(f, 10)
+	}
+	// Obtener el lock
+	in := d.acquireInput(l, now, fence)
+	in.ReturnValues = types.ReturnValueUpdatedNew
+	uio, err := d.svc.UpdateItem(ctx, in)
+	if err == nil {
+		return itemNumber(uio.Attributes, "fence"), nil
+	}
+	var ccfe *types.ConditionalCheckFailedException
+	if errors.As(err, &ccfe) {
+		if d.writerWait > 0 && blockedByReaders(ccfe.Item, now) {
+			d.markWriterWaiting(ctx, l, now)
+		}
+		return "", heldError(l, ccfe.Item, ccfe, false)
+	}
+	return "", serviceError(err)
+}
+
+// acquireInput prepara la escritura condicional que adquiere l en modo
+// exclusivo con el fence dado; con fence "" el fence sale del contador del
+// propio lock (WithPerLockFence).
+func (d *dynamolock) acquireInput(l *lock, now int64, fence string) *dynamodb.UpdateItemInput {
+	names := map[string]string{
+		"#tabla":         "tabla",
+		"#releasetime":   "releasetime",
+		"#fence":         "fence",
+		"#lockname":      "lockname",
+		"#locktype":      "locktype",
+		"#startingtime":  "startingTime",
+		"#startingms":    "startingtimems",
+		"#owner":         "owner",
+		"#readers":       "readers",
+		"#readerrelease": "readerrelease",
+		"#writerwaiting": "writerwaiting",
+	}
+	values := map[string]types.AttributeValue{
+		":zero":          &types.AttributeValueMemberN{Value: "0"},
+		":releasetime":   &types.AttributeValueMemberN{Value: strconv.FormatInt(ceilSeconds(l.releaseTime), 10)},
+		":releasetimems": &types.AttributeValueMemberN{Value: strconv.FormatInt(l.releaseTime, 10)},
+		":lockname":      &types.AttributeValueMemberS{Value: l.lockName},
+		":locktype":      &types.AttributeValueMemberS{Value: l.lockType},
+		":startingtime":  &types.AttributeValueMemberS{Value: strconv.FormatInt(l.startingTime/1000, 10)},
+		":startingms":    &types.AttributeValueMemberN{Value: strconv.FormatInt(l.startingTime, 10)},
+		":owner":         &types.AttributeValueMemberS{Value: l.owner},
 	}
-	// Ya tiene adquirido el lock. Pregunta: Así, no error, o devolver error?
-	if l.fence != "0" {
-		return nil
+	leaseTerms(names, values, now)
+	update := "SET #releasetime = :releasetime, #releasetimems = :releasetimems, " +
+		"#lockname = :lockname, #locktype = :locktype, " +
+		"#startingtime = :startingtime, #startingms = :startingms, #owner = :owner, "
+	if fence == "" {
+		// Los dos SET leen el contador anterior, asi que ambos quedan con el
+		// valor nuevo
+		update += counterFence(names, values, l.clock.Now().UnixMicro()) + ", #fence = if_not_exists(#counter, :seed) + :uno"
+	} else {
+		values[":fence"] = &types.AttributeValueMemberN{Value: fence}
+		update += "#fence = :fence"
 	}
-	// Obtener del fencing global e incrementarlo
-	uio, err := l.svc.UpdateItem(
+	// Los lectores que quedasen ya vencieron o no hay ninguno
+	update += " REMOVE #readers, #readerrelease, #writerwaiting"
+	return &dynamodb.UpdateItemInput{
+		TableName: aws.String(d.lockTable),
+		Key: map[string]types.AttributeValue{
+			"tabla":     &types.AttributeValueMemberS{Value: l.table},
+			"lockvalue": &types.AttributeValueMemberS{Value: l.lockValue},
+		},
+		ConditionExpression: aws.String(
+			"(attribute_not_exists(#tabla) OR " +
+				"#fence = :zero OR " +
+				leaseExpired + ") AND " +
+				"(attribute_not_exists(#readers) OR " +
+				"#readerrelease < :now)",
+		),
+		ExpressionAttributeNames:            names,
+		ExpressionAttributeValues:           values,
+		UpdateExpression:                    aws.String(update),
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	}
+}
+
+// counterFence anade a names/values lo necesario para sacar el fence del
+// contador del propio lock en la misma escritura condicional, y devuelve la
+// accion SET que lo incrementa. El fence nuevo queda en "fencecounter". Si el
+// TTL borra el item el contador vuelve a empezar en seed (los microsegundos
+// actuales segun el reloj del lock), que es mayor que cualquier fence anterior
+// mientras no haya mas de un acquire por microsegundo sobre el mismo lock.
+func counterFence(names map[string]string, values map[string]types.AttributeValue, seed int64) string {
+	names["#counter"] = "fencecounter"
+	values[":uno"] = &types.AttributeValueMemberN{Value: "1"}
+	values[":seed"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(seed, 10)}
+	return "#counter = if_not_exists(#counter, :seed) + :uno"
+}
+
+// nextFence incrementa en n el fencing global del item lockTable/lockTable y
+// devuelve el nuevo valor; los fences reservados son los n ultimos.
+func (d *dynamolock) nextFence(ctx context.Context, n int) (int64, error) {
+	uio, err := d.svc.UpdateItem(ctx,
 		&dynamodb.UpdateItemInput{
-			TableName: aws.String(lockTable),
-			Key: map[string]*dynamodb.AttributeValue{
-				"tabla":     {S: aws.String(lockTable)},
-				"lockvalue": {S: aws.String(lockTable)},
-			},
-			ExpressionAttributeNames: map[string]*string{
-				"#fence": aws.String("fence"),
+			TableName: aws.String(d.lockTable),
+			Key:       fenceKey(d.lockTable),
+			ExpressionAttributeNames: map[string]string{
+				"#fence": "fence",
 			},
-			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
-				":uno": {N: aws.String("1")},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":uno": &types.AttributeValueMemberN{Value: strconv.Itoa(n)},
 			},
 			UpdateExpression: aws.String(
 				"SET #fence = #fence + :uno",
 			),
-			ReturnValues: aws.String("UPDATED_NEW"),
+			ReturnValues: types.ReturnValueUpdatedNew,
 		},
 	)
 	if err != nil {
-		return err
+		return 0, serviceError(err)
 	}
-	fence := uio.Attributes["fence"].N
-	// Obtener el lock
-	_, err = l.svc.UpdateItem(
+	return strconv.ParseInt(itemNumber(uio.Attributes, "fence"), 10, 64)
+}
+
+func (d *dynamolock) renew(ctx context.Context, l *lock, nrt, now int64) error {
+	if d.shared {
+		return d.renewShared(ctx, l, nrt, now)
+	}
+	if d.versioned {
+		return d.renewVersioned(ctx, l, nrt, now)
+	}
+	names := map[string]string{
+		"#fence": "fence",
+		"#owner": "owner",
+	}
+	values := map[string]types.AttributeValue{
+		":fence": &types.AttributeValueMemberN{Value: l.fence},
+		":owner": &types.AttributeValueMemberS{Value: l.owner},
+		":nrt":   &types.AttributeValueMemberN{Value: strconv.FormatInt(ceilSeconds(nrt), 10)},
+		":nrtms": &types.AttributeValueMemberN{Value: strconv.FormatInt(nrt, 10)},
+	}
+	leaseTerms(names, values, now)
+	_, err := d.svc.UpdateItem(ctx,
 		&dynamodb.UpdateItemInput{
-			TableName: aws.String(lockTable),
-			Key: map[string]*dynamodb.AttributeValue{
-				"tabla":     {S: aws.String(l.table)},
-				"lockvalue": {S: aws.String(l.lockValue)},
+			TableName: aws.String(d.lockTable),
+			Key: map[string]types.AttributeValue{
+				"tabla":     &types.AttributeValueMemberS{Value: l.table},
+				"lockvalue": &types.AttributeValueMemberS{Value: l.lockValue},
 			},
 			ConditionExpression: aws.String(
-				"attribute_not_exists(#tabla) OR " +
-					"#fence = :zero OR " +
-					"#releasetime < :now",
+				"#fence = :fence AND " +
+					"#owner = :owner AND " +
+					leaseLive,
 			),
-			ExpressionAttributeNames: map[string]*string{
-				"#tabla":        aws.String("tabla"),
-				"#releasetime":  aws.String("releasetime"),
-				"#fence":        aws.String("fence"),
-				"#lockname":     aws.String("lockname"),
-				"#locktype":     aws.String("locktype"),
-				"#startingtime": aws.String("startingTime"),
-			},
-			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
-				":zero":         {N: aws.String("0")},
-				":fence":        {N: fence},
-				":now":          {N: aws.String(strconv.FormatInt(now, 10))},
-				":releasetime":  {N: aws.String(strconv.FormatInt(l.releaseTime, 10))},
-				":lockname":     {S: aws.String(l.lockName)},
-				":locktype":     {S: aws.String(l.lockType)},
-				":startingtime": {S: aws.String(strconv.FormatInt(l.startingTime, 10))},
-			},
+			ExpressionAttributeNames:  names,
+			ExpressionAttributeValues: values,
 			UpdateExpression: aws.String(
-				"SET #releasetime = :releasetime, #fence = :fence, #lockname = :lockname, " +
-					"#locktype = :locktype, #startingtime = :startingtime",
+				"SET #releasetime = :nrt, #releasetimems = :nrtms",
 			),
+			ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
 		},
 	)
-	// Si se obtuvo el lock registrar el fence
-	if err == nil {
-		l.fence = *fence
-	}
-	return err
+	return ownerError(l, err)
+}
 
+func (d *dynamolock) release(ctx context.Context, l *lock, now int64) error {
+	if d.shared {
+		return d.releaseShared(ctx, l, now)
+	}
+	_, err := d.svc.UpdateItem(ctx, d.releaseInput(l, now))
+	return ownerError(l, err)
 }
 
-func (l *dynamolock) NewDuration(duration time.Duration) error {
-	// Lock no adquirido no se puede cambiar duracion
-	if l.fence == "0" {
-		return errors.New("error: Lock no adquired, no new duration")
+// releaseInput prepara la escritura condicional que libera l en modo
+// exclusivo.
+func (d *dynamolock) releaseInput(l *lock, now int64) *dynamodb.UpdateItemInput {
+	if d.versioned {
+		return d.releaseVersionedInput(l)
 	}
-	now := time.Now().UTC()
-	// Lock expirado no se puede cambiar duracion
-	if l.releaseTime <= now.Unix() {
-		l.fence = "0"
-		return errors.New("error: not creating an expired lock")
+	names := map[string]string{
+		"#fence": "fence",
+		"#owner": "owner",
 	}
-	nrt := now.Add(duration).Unix()
-	_, err := l.svc.UpdateItem(
-		&dynamodb.UpdateItemInput{
-			TableName: aws.String(lockTable),
-			Key: map[string]*dynamodb.AttributeValue{
-				"tabla":     {S: aws.String(l.table)},
-				"lockvalue": {S: aws.String(l.lockValue)},
-			},
-			ConditionExpression: aws.String(
-				"#fence > :zero AND " +
-					"#releasetime > :now",
-			),
-			ExpressionAttributeNames: map[string]*string{
-				"#fence":       aws.String("fence"),
-				"#releasetime": aws.String("releasetime"),
-			},
-			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
-
-				":zero": {N: aws.String("0")},
-				":nrt":  {N: aws.String(strconv.FormatInt(nrt, 10))},
-				":now":  {N: aws.String(strconv.FormatInt(now.Unix(), 10))},
-			},
-			UpdateExpression: aws.String(
-				"SET #releasetime = :nrt",
-			),
+	values := map[string]types.AttributeValue{
+		":zero":  &types.AttributeValueMemberN{Value: "0"},
+		":fence": &types.AttributeValueMemberN{Value: l.fence},
+		":owner": &types.AttributeValueMemberS{Value: l.owner},
+	}
+	leaseTerms(names, values, now)
+	return &dynamodb.UpdateItemInput{
+		TableName: aws.String(d.lockTable),
+		Key: map[string]types.AttributeValue{
+			"tabla":     &types.AttributeValueMemberS{Value: l.table},
+			"lockvalue": &types.AttributeValueMemberS{Value: l.lockValue},
 		},
-	)
-	if err == nil {
-		l.releaseTime = nrt
+		ConditionExpression: aws.String(
+			"#fence = :fence AND " +
+				"#owner = :owner AND " +
+				leaseLive,
+		),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+		UpdateExpression: aws.String(
+			"SET #fence = :zero",
+		),
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
 	}
-	return err
 }
 
-func (l *dynamolock) Release() error {
-	// Lock no adquirido no se puede hacer release
-	if l.fence == "0" {
-		return errors.New("error: lock no adquirido")
+// heldError construye el *HeldError a partir del registro devuelto por la
+// condicion fallida: el escritor si esta vivo, y los lectores si los hay.
+// reader indica que el que intentaba adquirir es un lector.
+func heldError(l *lock, item map[string]types.AttributeValue, cause error, reader bool) error {
+	held := &HeldError{
+		Table:     l.table,
+		LockValue: l.lockValue,
+		Readers:   len(itemStringSet(item, "readers")),
+		Err:       cause,
 	}
-	now := time.Now().UTC().Unix()
-	// Lock expirado no se puede hacer release
-	// No es necesario ir a la base de datos, "confiamos" en el reloj de lambda y
-	// los problemas se evitan mediante fencing
-	if l.releaseTime <= now {
-		l.fence = "0"
-		return errors.New("error: lock expirado")
+	if fence := itemNumber(item, "fence"); fence != "0" {
+		held.LockName = itemString(item, "lockname")
+		held.LockType = itemString(item, "locktype")
+		held.Fence = fence
+		held.ReleaseTime = itemLease(item)
 	}
-	_, err := l.svc.UpdateItem(
-		&dynamodb.UpdateItemInput{
-			TableName: aws.String(lockTable),
-			Key: map[string]*dynamodb.AttributeValue{
-				"tabla":     {S: aws.String(l.table)},
-				"lockvalue": {S: aws.String(l.lockValue)},
-			},
-			ConditionExpression: aws.String(
-				"#fence > :zero AND " +
-					"#releasetime > :now",
-			),
-			ExpressionAttributeNames: map[string]*string{
-				"#fence":       aws.String("fence"),
-				"#releasetime": aws.String("releasetime"),
-			},
-			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
-				":zero": {N: aws.String("0")},
-				":now":  {N: aws.String(strconv.FormatInt(now, 10))},
-			},
-			UpdateExpression: aws.String(
-				"SET #fence = :zero",
-			),
-		},
-	)
-	if err == nil {
-		l.fence = "0"
+	if t := itemSecondsEnd(item, "readerrelease"); held.Readers > 0 && t.After(held.ReleaseTime) {
+		held.ReleaseTime = t
+	}
+	// Al lector tambien le bloquea el escritor en espera
+	if t := itemSecondsEnd(item, "writerwaiting"); reader && t.After(held.ReleaseTime) {
+		held.ReleaseTime = t
 	}
-	return err
+	return held
 }
 
-func (l *lock) RemainingDuration() time.Duration {
-	// Lock no aquirido
-	if l.fence == "0" {
-		return ZeroDuration
+// ownerError traduce el fallo de la condicion de Release/NewDuration a
+// ErrNotOwner cuando el registro ya no es de este handle (otro fence u owner).
+func ownerError(l *lock, err error) error {
+	var ccfe *types.ConditionalCheckFailedException
+	if err == nil || !errors.As(err, &ccfe) {
+		return serviceError(err)
 	}
-	now := time.Now().UTC().Unix()
-	// Lock expirado
-	if l.releaseTime <= now {
-		l.fence = "0"
-		return ZeroDuration
+	return recordError(l, ccfe.Item, err)
+}
+
+// recordError decide, con el item que hizo fallar la condicion, si el lock es
+// de otro holder o si es nuestro pero vencio.
+func recordError(l *lock, item map[string]types.AttributeValue, err error) error {
+	if itemNumber(item, "fence") != l.fence || itemString(item, "owner") != l.owner {
+		return ErrNotOwner
 	}
-	// Calcular tiempo restante en segundos
-	return time.Duration((l.releaseTime - now) * 1000000000)
+	// Es nuestro registro pero el lease vencio segun el reloj de DynamoDB
+	return fmt.Errorf("%w: %w", ErrExpired, err)
 }
 
-func (l *lock) Fence() string {
-	now := time.Now().UTC().Unix()
-	// Lock expirado, cuando se vaya escribir en la base de datos
-	// le evita chequear la consistencia mediante el fencing
-	if l.releaseTime <= now {
-		l.fence = "0"
+// itemString devuelve el atributo S name del item, o "" si no existe.
+func itemString(item map[string]types.AttributeValue, name string) string {
+	if v, ok := item[name].(*types.AttributeValueMemberS); ok {
+		return v.Value
 	}
-	return l.fence
+	return ""
 }
 
+// itemStringSet devuelve el atributo SS name del item, o nil si no existe.
+func itemStringSet(item map[string]types.AttributeValue, name string) []string {
+	if v, ok := item[name].(*types.AttributeValueMemberSS); ok {
+		return v.Value
+	}
+	return nil
+}
 
+// itemTime devuelve el atributo N name del item, en segundos Unix, como
+// time.Time; el valor cero si no existe.
+func itemTime(item map[string]types.AttributeValue, name string) time.Time {
+	if v, err := strconv.ParseInt(itemNumber(item, name), 10, 64); err == nil {
+		return time.Unix(v, 0).UTC()
+	}
+	return time.Time{}
+}
 
+// itemSecondsEnd es como itemTime pero con el ultimo milisegundo de ese
+// segundo: las condiciones comparan en segundos, asi que lo que vence en el
+// segundo t sigue vigente hasta que acaba.
+func itemSecondsEnd(item map[string]types.AttributeValue, name string) time.Time {
+	t := itemTime(item, name)
+	if t.IsZero() {
+		return t
+	}
+	return t.Add(time.Second - time.Millisecond)
+}
+
+// itemLease devuelve el fin del lease del escritor del item: releasetimems
+// si existe, releasetime (segundos) si no.
+func itemLease(item map[string]types.AttributeValue) time.Time {
+	if v, err := strconv.ParseInt(itemNumber(item, "releasetimems"), 10, 64); err == nil {
+		return time.UnixMilli(v).UTC()
+	}
+	return itemSecondsEnd(item, "releasetime")
+}
+
+// itemNumber devuelve el atributo N name del item, o "" si no existe.
+func itemNumber(item map[string]types.AttributeValue, name string) string {
+	if v, ok := item[name].(*types.AttributeValueMemberN); ok {
+		return v.Value
+	}
+	return ""
+}
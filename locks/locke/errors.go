@@ -0,0 +1,92 @@
+package locke
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/smithy-go"
+)
+
+var (
+	// ErrHeld: el lock lo tiene otro holder. El error concreto es *HeldError.
+	ErrHeld = errors.New("error: lock held by another holder")
+	// ErrExpired: el lease del handle vencio segun el reloj local.
+	ErrExpired = errors.New("error: lock expired")
+	// ErrNotAcquired: la operacion necesita un lock adquirido.
+	ErrNotAcquired = errors.New("error: lock not acquired")
+	// ErrTableMisconfigured: la tabla de locks existe pero no sirve; el
+	// detalle esta en el *TableError.
+	ErrTableMisconfigured = errors.New("error: lock table misconfigured")
+	// ErrLockNotFound: no hay item para el lock en LockTable.
+	ErrLockNotFound = errors.New("error: lock not found")
+	// ErrAlreadyHeld: Acquire sobre un handle que ya tiene el lock, sin
+	// WithReentrant.
+	ErrAlreadyHeld = errors.New("error: lock already held by this handle")
+	// ErrNotOwner: el registro del lock pertenece a otro holder (otro fence u
+	// owner), normalmente porque el lease vencio y otro lo adquirio.
+	ErrNotOwner = errors.New("error: lock not owned by this handle")
+	// ErrStaleFence: una escritura con fence encontro un fence mas nuevo, es
+	// decir, otro holder adquirio el lock despues que nosotros.
+	ErrStaleFence = errors.New("error: stale fence, the lock has a newer holder")
+	// ErrTableNotFound: no existe la tabla de locks.
+	ErrTableNotFound = errors.New("error: lock table not found")
+	// ErrThrottled: el servicio rechazo la peticion por capacidad.
+	ErrThrottled = errors.New("error: lock service throttled the request")
+	// ErrUnknownService: svcType no corresponde a ningun backend.
+	ErrUnknownService = errors.New("error: unknown lock service")
+)
+
+// HeldError describe al holder actual cuando no se pudo adquirir el lock.
+// errors.Is(err, ErrHeld) es cierto para un *HeldError.
+type HeldError struct {
+	Table     string
+	LockValue string
+	LockName  string
+	LockType  string
+	Fence     string
+	// Readers es el numero de lectores registrados en un lock compartido.
+	Readers int
+	// ReleaseTime es cuando vence el holder (o el ultimo lector, o el
+	// escritor en espera), lo que sea mas tarde.
+	ReleaseTime time.Time
+	Err         error
+}
+
+func (e *HeldError) Error() string {
+	if e.LockName == "" && e.Readers > 0 {
+		return fmt.Sprintf("error: lock %s/%s held by %d readers until %s",
+			e.Table, e.LockValue, e.Readers, e.ReleaseTime.Format(time.RFC3339))
+	}
+	if e.LockName == "" {
+		return fmt.Sprintf("error: lock %s/%s held by another holder", e.Table, e.LockValue)
+	}
+	return fmt.Sprintf("error: lock %s/%s held by %s (type %s, fence %s) until %s",
+		e.Table, e.LockValue, e.LockName, e.LockType, e.Fence, e.ReleaseTime.Format(time.RFC3339))
+}
+
+func (e *HeldError) Is(target error) bool {
+	return target == ErrHeld
+}
+
+func (e *HeldError) Unwrap() error {
+	return e.Err
+}
+
+// serviceError envuelve los errores de DynamoDB que tienen sentinel propio,
+// conservando el error original en la cadena.
+func serviceError(err error) error {
+	var aerr smithy.APIError
+	if err == nil || !errors.As(err, &aerr) {
+		return err
+	}
+	switch aerr.ErrorCode() {
+	case "ResourceNotFoundException":
+		return fmt.Errorf("%w: %w", ErrTableNotFound, err)
+	case "ProvisionedThroughputExceededException",
+		"RequestLimitExceeded",
+		"ThrottlingException":
+		return fmt.Errorf("%w: %w", ErrThrottled, err)
+	}
+	return err
+}
@@ -0,0 +1,159 @@
+package locke
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Clientes minimos para las escrituras con fence. *dynamodb.Client los
+// implementa.
+type (
+	PutItemAPI interface {
+		PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+	}
+	UpdateItemAPI interface {
+		UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
+	}
+	DeleteItemAPI interface {
+		DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error)
+	}
+)
+
+// Placeholders que usan los helpers; no deben aparecer en las expresiones
+// del caller.
+const (
+	fenceName  = "#lockeFence"
+	fenceValue = ":lockeFence"
+)
+
+// La condicion admite el mismo fence para que el holder pueda escribir
+// varias veces el mismo item durante su lease; un holder anterior siempre
+// tiene un fence menor que el guardado.
+const fenceCondition = "(attribute_not_exists(" + fenceName + ") OR " + fenceName + " <= " + fenceValue + ")"
+
+// setClause es la palabra SET que abre una clausula: al principio o despues
+// de un espacio, asi que no confunde #set ni :set con la clausula.
+var setClause = regexp.MustCompile(`(?i)(^|\s)SET\b`)
+
+// PutItemFenced hace el PutItem solo si el item no existe o su atributo attr
+// tiene un fence menor o igual que el de l, y guarda el fence de l en attr.
+// Si el item tiene un fence mayor devuelve ErrStaleFence.
+func PutItemFenced(ctx context.Context, svc PutItemAPI, l Lock, attr string, in *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	fence, err := currentFence(l)
+	if err != nil {
+		return nil, err
+	}
+	params := *in
+	params.Item = make(map[string]types.AttributeValue, len(in.Item)+1)
+	for k, v := range in.Item {
+		params.Item[k] = v
+	}
+	params.Item[attr] = &types.AttributeValueMemberN{Value: fence}
+	params.ConditionExpression = andFenceCondition(in.ConditionExpression)
+	params.ExpressionAttributeNames = withFenceName(in.ExpressionAttributeNames, attr)
+	params.ExpressionAttributeValues = withFenceValue(in.ExpressionAttributeValues, fence)
+	params.ReturnValuesOnConditionCheckFailure = types.ReturnValuesOnConditionCheckFailureAllOld
+	out, err := svc.PutItem(ctx, &params)
+	return out, staleFenceError(err, attr, fence)
+}
+
+// UpdateItemFenced hace el UpdateItem con la misma condicion de fence que
+// PutItemFenced y anade "SET attr = fence" a la expresion de update.
+func UpdateItemFenced(ctx context.Context, svc UpdateItemAPI, l Lock, attr string, in *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	fence, err := currentFence(l)
+	if err != nil {
+		return nil, err
+	}
+	params := *in
+	set := fenceName + " = " + fenceValue
+	switch update := aws.ToString(in.UpdateExpression); {
+	case update == "":
+		params.UpdateExpression = aws.String("SET " + set)
+	case setClause.MatchString(update):
+		// Solo puede haber una clausula SET
+		loc := setClause.FindStringIndex(update)
+		params.UpdateExpression = aws.String(update[:loc[1]] + " " + set + "," + update[loc[1]:])
+	default:
+		params.UpdateExpression = aws.String("SET " + set + " " + update)
+	}
+	params.ConditionExpression = andFenceCondition(in.ConditionExpression)
+	params.ExpressionAttributeNames = withFenceName(in.ExpressionAttributeNames, attr)
+	params.ExpressionAttributeValues = withFenceValue(in.ExpressionAttributeValues, fence)
+	params.ReturnValuesOnConditionCheckFailure = types.ReturnValuesOnConditionCheckFailureAllOld
+	out, err := svc.UpdateItem(ctx, &params)
+	return out, staleFenceError(err, attr, fence)
+}
+
+// DeleteItemFenced hace el DeleteItem con la misma condicion de fence que
+// PutItemFenced.
+func DeleteItemFenced(ctx context.Context, svc DeleteItemAPI, l Lock, attr string, in *dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error) {
+	fence, err := currentFence(l)
+	if err != nil {
+		return nil, err
+	}
+	params := *in
+	params.ConditionExpression = andFenceCondition(in.ConditionExpression)
+	params.ExpressionAttributeNames = withFenceName(in.ExpressionAttributeNames, attr)
+	params.ExpressionAttributeValues = withFenceValue(in.ExpressionAttributeValues, fence)
+	params.ReturnValuesOnConditionCheckFailure = types.ReturnValuesOnConditionCheckFailureAllOld
+	out, err := svc.DeleteItem(ctx, &params)
+	return out, staleFenceError(err, attr, fence)
+}
+
+// currentFence devuelve el fence de l, o ErrNotAcquired si no lo tiene o su
+// lease vencio.
+func currentFence(l Lock) (string, error) {
+	fence := l.Fence()
+	if fence == "0" {
+		return "", ErrNotAcquired
+	}
+	return fence, nil
+}
+
+func andFenceCondition(cond *string) *string {
+	if aws.ToString(cond) == "" {
+		return aws.String(fenceCondition)
+	}
+	return aws.String("(" + *cond + ") AND " + fenceCondition)
+}
+
+func withFenceName(names map[string]string, attr string) map[string]string {
+	res := make(map[string]string, len(names)+1)
+	for k, v := range names {
+		res[k] = v
+	}
+	res[fenceName] = attr
+	return res
+}
+
+func withFenceValue(values map[string]types.AttributeValue, fence string) map[string]types.AttributeValue {
+	res := make(map[string]types.AttributeValue, len(values)+1)
+	for k, v := range values {
+		res[k] = v
+	}
+	res[fenceValue] = &types.AttributeValueMemberN{Value: fence}
+	return res
+}
+
+// staleFenceError devuelve ErrStaleFence si la condicion fallo porque el item
+// tiene un fence mayor que el nuestro; si fallo la condicion del caller deja
+// el error como esta.
+func staleFenceError(err error, attr, fence string) error {
+	var ccfe *types.ConditionalCheckFailedException
+	if err == nil || !errors.As(err, &ccfe) {
+		return serviceError(err)
+	}
+	stored, err1 := strconv.ParseInt(itemNumber(ccfe.Item, attr), 10, 64)
+	mine, err2 := strconv.ParseInt(fence, 10, 64)
+	if err1 == nil && err2 == nil && stored > mine {
+		return fmt.Errorf("%w: %w", ErrStaleFence, err)
+	}
+	return err
+}
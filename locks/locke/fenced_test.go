@@ -0,0 +1,142 @@
+package locke
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// newDataTable crea en svc la tabla Datos, con clave id, para las escrituras
+// protegidas por un lock.
+func newDataTable(t *testing.T, svc *dynamodb.Client) {
+	t.Helper()
+	_, err := svc.CreateTable(context.Background(), &dynamodb.CreateTableInput{
+		TableName:   aws.String("Datos"),
+		BillingMode: types.BillingModePayPerRequest,
+		KeySchema: []types.KeySchemaElement{
+			{AttributeName: aws.String("id"), KeyType: types.KeyTypeHash},
+		},
+		AttributeDefinitions: []types.AttributeDefinition{
+			{AttributeName: aws.String("id"), AttributeType: types.ScalarAttributeTypeS},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFencedWrites(t *testing.T) {
+	ctx := context.Background()
+	svc := newFakeDynamo(t)
+	newDataTable(t, svc)
+	key := map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: "x"}}
+	get := func() map[string]types.AttributeValue {
+		t.Helper()
+		gio, err := svc.GetItem(ctx, &dynamodb.GetItemInput{TableName: aws.String("Datos"), Key: key})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return gio.Item
+	}
+	put := func(l Lock) error {
+		_, err := PutItemFenced(ctx, svc, l, "fence", &dynamodb.PutItemInput{
+			TableName: aws.String("Datos"),
+			Item: map[string]types.AttributeValue{
+				"id": &types.AttributeValueMemberS{Value: "x"},
+				"n":  &types.AttributeValueMemberN{Value: "1"},
+			},
+		})
+		return err
+	}
+	update := func(l Lock, expr string, names map[string]string, values map[string]types.AttributeValue) error {
+		_, err := UpdateItemFenced(ctx, svc, l, "fence", &dynamodb.UpdateItemInput{
+			TableName:                 aws.String("Datos"),
+			Key:                       key,
+			UpdateExpression:          aws.String(expr),
+			ExpressionAttributeNames:  names,
+			ExpressionAttributeValues: values,
+		})
+		return err
+	}
+	del := func(l Lock, cond string) error {
+		in := &dynamodb.DeleteItemInput{TableName: aws.String("Datos"), Key: key}
+		if cond != "" {
+			in.ConditionExpression = aws.String(cond)
+			in.ExpressionAttributeNames = map[string]string{"#id": "id"}
+		}
+		_, err := DeleteItemFenced(ctx, svc, l, "fence", in)
+		return err
+	}
+
+	l1 := dynamoLock(t, svc, "Pepe", "Lock1", time.Minute)
+	if err := put(l1); !errors.Is(err, ErrNotAcquired) {
+		t.Fatalf("write without the lock: got %v, want ErrNotAcquired", err)
+	}
+	if err := l1.Acquire(); err != nil {
+		t.Fatal(err)
+	}
+	if err := put(l1); err != nil {
+		t.Fatal(err)
+	}
+	if f := itemNumber(get(), "fence"); f != l1.Fence() {
+		t.Fatalf("stored fence %s, want %s", f, l1.Fence())
+	}
+	// El fence se anade a la clausula SET, no a placeholders que se llamen set
+	updates := []struct {
+		expr   string
+		names  map[string]string
+		values map[string]types.AttributeValue
+	}{
+		{"", nil, nil},
+		{"REMOVE #set", map[string]string{"#set": "set"}, nil},
+		{"ADD #n :set", map[string]string{"#n": "n"}, map[string]types.AttributeValue{":set": &types.AttributeValueMemberN{Value: "1"}}},
+		{"set #n = #n + :uno", map[string]string{"#n": "n"}, map[string]types.AttributeValue{":uno": &types.AttributeValueMemberN{Value: "1"}}},
+		{"REMOVE #set SET #n = #n + :set", map[string]string{"#n": "n", "#set": "set"}, map[string]types.AttributeValue{":set": &types.AttributeValueMemberN{Value: "1"}}},
+	}
+	for _, u := range updates {
+		if err := update(l1, u.expr, u.names, u.values); err != nil {
+			t.Fatalf("%q: %v", u.expr, err)
+		}
+	}
+	if n := itemNumber(get(), "n"); n != "4" {
+		t.Fatalf("n = %s after the updates, want 4", n)
+	}
+
+	// Otro holder escribe con un fence mayor; el anterior ya no puede
+	if _, err := ForceRelease(ctx, svc, "Usuarios", "Pepe"); err != nil {
+		t.Fatal(err)
+	}
+	l2 := acquired(t, dynamoLock(t, svc, "Pepe", "Lock2", time.Minute))
+	if err := update(l2, "SET #n = :cero", map[string]string{"#n": "n"}, map[string]types.AttributeValue{":cero": &types.AttributeValueMemberN{Value: "0"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := put(l1); !errors.Is(err, ErrStaleFence) {
+		t.Fatalf("PutItemFenced with old fence: got %v, want ErrStaleFence", err)
+	}
+	if err := update(l1, "", nil, nil); !errors.Is(err, ErrStaleFence) {
+		t.Fatalf("UpdateItemFenced with old fence: got %v, want ErrStaleFence", err)
+	}
+	if err := del(l1, ""); !errors.Is(err, ErrStaleFence) {
+		t.Fatalf("DeleteItemFenced with old fence: got %v, want ErrStaleFence", err)
+	}
+	if n := itemNumber(get(), "n"); n != "0" {
+		t.Fatalf("stale writes changed n to %s", n)
+	}
+
+	// Si falla la condicion del caller el error no es ErrStaleFence
+	var ccfe *types.ConditionalCheckFailedException
+	if err := del(l2, "attribute_not_exists(#id)"); !errors.As(err, &ccfe) || errors.Is(err, ErrStaleFence) {
+		t.Fatalf("caller condition: got %v, want ConditionalCheckFailedException", err)
+	}
+	if err := del(l2, ""); err != nil {
+		t.Fatal(err)
+	}
+	if item := get(); item != nil {
+		t.Fatalf("item not deleted: %v", item)
+	}
+}
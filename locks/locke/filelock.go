@@ -0,0 +1,211 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package locke
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// filelock guarda los locks en ficheros de un directorio local: uno por lock,
+// dir/<table>/<lockValue>.lock, con el registro en JSON, y un contador de
+// fence global en dir/.locke/fence. Cada operacion toma flock sobre el fichero
+// que modifica, asi que sirve para procesos de un mismo host.
+type filelock struct {
+	dir string
+}
+
+// fileRecord es el equivalente del item de LockTable, con los instantes en
+// milisegundos Unix.
+type fileRecord struct {
+	Fence        string `json:"fence"`
+	Owner        string `json:"owner"`
+	LockName     string `json:"lockname"`
+	LockType     string `json:"locktype"`
+	StartingTime int64  `json:"startingtimems"`
+	ReleaseTime  int64  `json:"releasetimems"`
+}
+
+func newFileLock(dir string) (backend, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &filelock{dir: dir}, nil
+}
+
+func (f *filelock) acquire(ctx context.Context, l *lock, now int64) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	var fence string
+	err := f.update(l, func(rec *fileRecord, exists bool) error {
+		if exists && rec.Fence != "0" && rec.ReleaseTime >= now {
+			return &HeldError{
+				Table:       l.table,
+				LockValue:   l.lockValue,
+				LockName:    rec.LockName,
+				LockType:    rec.LockType,
+				Fence:       rec.Fence,
+				ReleaseTime: time.UnixMilli(rec.ReleaseTime).UTC(),
+			}
+		}
+		// El contador se incrementa con el fichero del lock tomado, asi no se
+		// gastan fences en intentos fallidos
+		var err error
+		fence, err = f.nextFence()
+		if err != nil {
+			return err
+		}
+		*rec = fileRecord{
+			Fence:        fence,
+			Owner:        l.owner,
+			LockName:     l.lockName,
+			LockType:     l.lockType,
+			StartingTime: l.startingTime,
+			ReleaseTime:  l.releaseTime,
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return fence, nil
+}
+
+func (f *filelock) renew(ctx context.Context, l *lock, nrt, now int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return f.update(l, func(rec *fileRecord, exists bool) error {
+		if err := rec.owned(l, exists, now); err != nil {
+			return err
+		}
+		rec.ReleaseTime = nrt
+		return nil
+	})
+}
+
+func (f *filelock) release(ctx context.Context, l *lock, now int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return f.update(l, func(rec *fileRecord, exists bool) error {
+		if err := rec.owned(l, exists, now); err != nil {
+			return err
+		}
+		rec.Fence = "0"
+		return nil
+	})
+}
+
+func (rec *fileRecord) owned(l *lock, exists bool, now int64) error {
+	if !exists || rec.Fence != l.fence || rec.Owner != l.owner {
+		return ErrNotOwner
+	}
+	if rec.ReleaseTime <= now {
+		return ErrExpired
+	}
+	return nil
+}
+
+// update lee el registro de l con el fichero bloqueado, aplica fn y lo
+// escribe si fn no devuelve error.
+func (f *filelock) update(l *lock, fn func(rec *fileRecord, exists bool) error) error {
+	if l.table == "" || l.lockValue == "" {
+		return errors.New("error: file locks need a table and a lock value")
+	}
+	path := filepath.Join(f.dir, fileName(l.table), fileName(l.lockValue)+".lock")
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return withFlock(path, func(file *os.File) error {
+		data, err := io.ReadAll(file)
+		if err != nil {
+			return err
+		}
+		var rec fileRecord
+		exists := len(data) > 0
+		if exists {
+			if err := json.Unmarshal(data, &rec); err != nil {
+				return err
+			}
+		}
+		if err := fn(&rec, exists); err != nil {
+			return err
+		}
+		data, err = json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		return rewrite(file, data)
+	})
+}
+
+// fileName escapa name para usarlo como nombre de fichero: sin separadores
+// y sin punto inicial, asi que no puede ser "." ni "..", salir de dir ni
+// coincidir con .locke.
+func fileName(name string) string {
+	name = url.PathEscape(name)
+	if strings.HasPrefix(name, ".") {
+		name = "%2E" + name[1:]
+	}
+	return name
+}
+
+// nextFence incrementa el contador global de fence y devuelve el nuevo valor.
+func (f *filelock) nextFence() (string, error) {
+	path := filepath.Join(f.dir, ".locke", "fence")
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", err
+	}
+	var fence string
+	err := withFlock(path, func(file *os.File) error {
+		data, err := io.ReadAll(file)
+		if err != nil {
+			return err
+		}
+		var n int64
+		if s := strings.TrimSpace(string(data)); s != "" {
+			if n, err = strconv.ParseInt(s, 10, 64); err != nil {
+				return err
+			}
+		}
+		fence = strconv.FormatInt(n+1, 10)
+		return rewrite(file, []byte(fence))
+	})
+	return fence, err
+}
+
+// withFlock abre path (creandolo si no existe) y llama a fn con flock
+// exclusivo sobre el fichero.
+func withFlock(path string, fn func(file *os.File) error) error {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+	defer syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+	return fn(file)
+}
+
+func rewrite(file *os.File, data []byte) error {
+	if err := file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := file.WriteAt(data, 0); err != nil {
+		return err
+	}
+	return file.Sync()
+}
@@ -0,0 +1,9 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package locke
+
+import "errors"
+
+func newFileLock(dir string) (backend, error) {
+	return nil, errors.New("error: file lock service needs flock, not available on this platform")
+}
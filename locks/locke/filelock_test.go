@@ -0,0 +1,49 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package locke
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileLockNames(t *testing.T) {
+	parent := t.TempDir()
+	dir := filepath.Join(parent, "locks")
+	// Nombres que coinciden con el contador o que saldrian de dir
+	names := [][2]string{
+		{"fence", "fence"},
+		{".locke", "fence"},
+		{"..", "Pepe"},
+		{".", ".."},
+		{"Usuarios", "../../Pepe"},
+	}
+	for _, n := range names {
+		l, err := NewLock("file", dir, n[0], n[1], "Lock1", time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := l.Acquire(); err != nil {
+			t.Fatalf("%q/%q: %v", n[0], n[1], err)
+		}
+		if err := l.Release(); err != nil {
+			t.Fatalf("%q/%q: %v", n[0], n[1], err)
+		}
+	}
+	entries, err := os.ReadDir(parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "locks" {
+		t.Fatalf("files written outside the lock directory: %v", entries)
+	}
+	l, err := NewLock("file", dir, "", "Pepe", "Lock1", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Acquire(); err == nil {
+		t.Fatal("Acquire with an empty table succeeded")
+	}
+}
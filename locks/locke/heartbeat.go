@@ -0,0 +1,105 @@
+package locke
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// HeartbeatOptions configura la renovacion automatica de un lock.
+type HeartbeatOptions struct {
+	// Duration es el nuevo lease en cada renovacion. Si es 0 se usa el
+	// tiempo restante del lock al arrancar el heartbeat.
+	Duration time.Duration
+	// Fraction de Duration entre renovaciones. Si es 0 se usa 0.5.
+	Fraction float64
+	// OnLost, si no es nil, se llama cuando se pierde el lock.
+	OnLost func(error)
+}
+
+// Heartbeat renueva en segundo plano el lease de un lock adquirido.
+type Heartbeat struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+	lost   chan error
+}
+
+// StartHeartbeat arranca la renovacion del lease de l. Se detiene con Stop, al
+// cancelarse ctx o al perder el lock: otro es el holder (ErrNotOwner) o el
+// lease vencio (ErrExpired). Los fallos del servicio, como throttling o
+// errores de red, no quieren decir que se perdio el lock: se reintenta
+// mientras quede lease. Al perderlo el error se envia por Lost y se llama a
+// OnLost. Las esperas usan el Clock del lock (WithClock).
+func StartHeartbeat(ctx context.Context, l Lock, opts HeartbeatOptions) (*Heartbeat, error) {
+	if opts.Duration == 0 {
+		opts.Duration = l.RemainingDuration()
+	}
+	if opts.Duration <= 0 {
+		return nil, ErrNotAcquired
+	}
+	if opts.Fraction <= 0 || opts.Fraction >= 1 {
+		opts.Fraction = 0.5
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	h := &Heartbeat{
+		cancel: cancel,
+		done:   make(chan struct{}),
+		lost:   make(chan error, 1),
+	}
+	clock := clockOf(l)
+	interval := time.Duration(float64(opts.Duration) * opts.Fraction)
+	go func() {
+		defer close(h.done)
+		defer close(h.lost)
+		wait := interval
+		// failed es el ultimo fallo del servicio sin renovar desde entonces
+		var failed error
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-clock.After(wait):
+			}
+			err := l.NewDurationContext(ctx, opts.Duration)
+			if err == nil {
+				wait, failed = interval, nil
+				continue
+			}
+			// Parado por Stop o por el contexto del holder, no es una perdida
+			if ctx.Err() != nil {
+				return
+			}
+			if !errors.Is(err, ErrNotOwner) && !errors.Is(err, ErrExpired) && !errors.Is(err, ErrNotAcquired) {
+				// Se reintenta antes de que venza el lease; cuando venza,
+				// NewDuration devuelve ErrExpired
+				failed = err
+				wait = l.RemainingDuration() / 2
+				if wait > interval {
+					wait = interval
+				}
+				continue
+			}
+			if failed != nil {
+				err = errors.Join(err, failed)
+			}
+			h.lost <- err
+			if opts.OnLost != nil {
+				opts.OnLost(err)
+			}
+			return
+		}
+	}()
+	return h, nil
+}
+
+// Lost recibe el error de la renovacion fallida, si la hay. Se cierra cuando
+// el heartbeat termina.
+func (h *Heartbeat) Lost() <-chan error {
+	return h.lost
+}
+
+// Stop detiene el heartbeat y espera a que termine la renovacion en curso.
+func (h *Heartbeat) Stop() {
+	h.cancel()
+	<-h.done
+}
@@ -0,0 +1,111 @@
+package locke
+
+import (
+	"context"
+	"dynamodb/locks/locke/locketest"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/smithy-go"
+)
+
+func TestHeartbeat(t *testing.T) {
+	clock := locketest.NewFakeClock(time.Now())
+	l, err := NewLock("memory", NewMemoryStore(), "Usuarios", "Pepe", "Lock1", time.Minute, WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := StartHeartbeat(context.Background(), l, HeartbeatOptions{}); !errors.Is(err, ErrNotAcquired) {
+		t.Fatalf("heartbeat before Acquire: got %v, want ErrNotAcquired", err)
+	}
+	if err := l.Acquire(); err != nil {
+		t.Fatal(err)
+	}
+	// Renueva el minuto entero cada medio minuto
+	hb, err := StartHeartbeat(context.Background(), l, HeartbeatOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		clock.BlockUntil(1)
+		clock.Advance(30 * time.Second)
+		clock.BlockUntil(1)
+		if d := l.RemainingDuration(); d != time.Minute {
+			t.Fatalf("lease %v after renewal, want 1m", d)
+		}
+	}
+	hb.Stop()
+	if err := <-hb.Lost(); err != nil {
+		t.Fatalf("Stop reported %v as lost", err)
+	}
+	if err := l.Release(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHeartbeatRetry(t *testing.T) {
+	svc := &renewFailingClient{Client: newFakeDynamo(t)}
+	clock := locketest.NewFakeClock(time.Now())
+	l := acquired(t, dynamoLock(t, svc, "Pepe", "Lock1", time.Minute, WithClock(clock)))
+	hb, err := StartHeartbeat(context.Background(), l, HeartbeatOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hb.Stop()
+	throttled := &smithy.GenericAPIError{Code: "ThrottlingException", Message: "slow down"}
+	svc.failRenewals(throttled)
+	clock.BlockUntil(1)
+	clock.Advance(30 * time.Second)
+	// Reintenta a mitad de lo que queda de lease, sin darlo por perdido
+	clock.BlockUntil(1)
+	select {
+	case err := <-hb.Lost():
+		t.Fatalf("throttled renewal reported as lost: %v", err)
+	default:
+	}
+	svc.failRenewals(nil)
+	clock.Advance(15 * time.Second)
+	clock.BlockUntil(1)
+	if d := l.RemainingDuration(); d != time.Minute {
+		t.Fatalf("lease %v after the retried renewal, want 1m", d)
+	}
+
+	// Sin servicio hasta que vence el lease
+	svc.failRenewals(throttled)
+	clock.BlockUntil(1)
+	clock.Advance(30 * time.Second)
+	clock.BlockUntil(1)
+	clock.Advance(time.Minute)
+	select {
+	case err := <-hb.Lost():
+		if !errors.Is(err, ErrExpired) || !errors.Is(err, ErrThrottled) {
+			t.Fatalf("got %v, want ErrExpired after ErrThrottled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("heartbeat did not report the expired lease")
+	}
+}
+
+func TestHeartbeatLost(t *testing.T) {
+	svc := newFakeDynamo(t)
+	clock := locketest.NewFakeClock(time.Now())
+	l := acquired(t, dynamoLock(t, svc, "Pepe", "Lock1", time.Minute, WithClock(clock)))
+	onLost := make(chan error, 1)
+	hb, err := StartHeartbeat(context.Background(), l, HeartbeatOptions{OnLost: func(err error) { onLost <- err }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hb.Stop()
+	if _, err := ForceRelease(context.Background(), svc, "Usuarios", "Pepe"); err != nil {
+		t.Fatal(err)
+	}
+	clock.BlockUntil(1)
+	clock.Advance(30 * time.Second)
+	if err := <-hb.Lost(); !errors.Is(err, ErrNotOwner) {
+		t.Fatalf("Lost: got %v, want ErrNotOwner", err)
+	}
+	if err := <-onLost; !errors.Is(err, ErrNotOwner) {
+		t.Fatalf("OnLost: got %v, want ErrNotOwner", err)
+	}
+}
@@ -0,0 +1,151 @@
+package locke
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// LockInfo es el estado de un item de LockTable.
+type LockInfo struct {
+	Table        string
+	LockValue    string
+	LockName     string
+	LockType     string
+	Owner        string
+	Fence        string
+	StartingTime time.Time
+	ReleaseTime  time.Time
+	// Readers son los lectores registrados si el lock es compartido.
+	Readers []string
+}
+
+// Held indica si el lock tenia holder en now: escritor con fence y lease
+// vigente, o lectores registrados.
+func (i LockInfo) Held(now time.Time) bool {
+	// Los lectores tienen precision de segundos, el escritor de milisegundos
+	if len(i.Readers) > 0 {
+		return !i.ReleaseTime.Before(now.Truncate(time.Second))
+	}
+	return i.Fence != "" && i.Fence != "0" && !i.ReleaseTime.Before(now.Truncate(time.Millisecond))
+}
+
+// Remaining es el lease que le queda al holder en now, 0 si no lo tiene.
+func (i LockInfo) Remaining(now time.Time) time.Duration {
+	if !i.Held(now) {
+		return ZeroDuration
+	}
+	return i.ReleaseTime.Sub(now)
+}
+
+// lockInfo lee un item de LockTable.
+func lockInfo(item map[string]types.AttributeValue) LockInfo {
+	info := LockInfo{
+		Table:       itemString(item, "tabla"),
+		LockValue:   itemString(item, "lockvalue"),
+		LockName:    itemString(item, "lockname"),
+		LockType:    itemString(item, "locktype"),
+		Owner:       itemString(item, "owner"),
+		Fence:       itemNumber(item, "fence"),
+		ReleaseTime: itemLease(item),
+		Readers:     itemStringSet(item, "readers"),
+	}
+	// startingTime se guarda como S, en segundos
+	if ms, err := strconv.ParseInt(itemNumber(item, "startingtimems"), 10, 64); err == nil {
+		info.StartingTime = time.UnixMilli(ms).UTC()
+	} else if st, err := strconv.ParseInt(itemString(item, "startingTime"), 10, 64); err == nil {
+		info.StartingTime = time.Unix(st, 0).UTC()
+	}
+	if len(info.Readers) > 0 {
+		info.ReleaseTime = itemTime(item, "readerrelease")
+	}
+	return info
+}
+
+// Describe lee el estado del lock table/lockValue en LockTable (o la tabla
+// de WithTableName). Si no hay item devuelve un LockInfo sin holder, con solo
+// Table y LockValue.
+func Describe(ctx context.Context, svc Client, table, lockValue string, opts ...Option) (LockInfo, error) {
+	out, err := svc.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(tableOption(opts)),
+		ConsistentRead: aws.Bool(true),
+		Key: map[string]types.AttributeValue{
+			"tabla":     &types.AttributeValueMemberS{Value: table},
+			"lockvalue": &types.AttributeValueMemberS{Value: lockValue},
+		},
+	})
+	if err != nil {
+		return LockInfo{}, serviceError(err)
+	}
+	if out.Item == nil {
+		return LockInfo{Table: table, LockValue: lockValue}, nil
+	}
+	return lockInfo(out.Item), nil
+}
+
+// List devuelve una pagina de hasta limit locks con holder de la particion
+// table de LockTable (limit <= 0 sin limite). Los items vencidos que el TTL
+// aun no ha borrado no aparecen. next es "" en la ultima pagina; si no se
+// pasa a la siguiente llamada como start. Al filtrar despues de leer, una
+// pagina puede venir con menos de limit locks, o vacia, sin ser la ultima.
+// De opts cuentan WithTableName y WithClock.
+func List(ctx context.Context, svc Client, table string, limit int32, start string, opts ...Option) (locks []LockInfo, next string, err error) {
+	now := optionsOf(opts).timeSource().Now()
+	return listLocks(ctx, svc, tableOption(opts), table, "", limit, start, now)
+}
+
+// listLocks hace una pagina de List en now, restringida a los lockvalue que
+// empiezan por prefix si no es "".
+func listLocks(ctx context.Context, svc Client, lockTable, table, prefix string, limit int32, start string, now time.Time) ([]LockInfo, string, error) {
+	now = now.UTC()
+	keyCond := "#tabla = :tabla"
+	names := map[string]string{
+		"#tabla":         "tabla",
+		"#fence":         "fence",
+		"#readers":       "readers",
+		"#readerrelease": "readerrelease",
+	}
+	values := map[string]types.AttributeValue{
+		":tabla": &types.AttributeValueMemberS{Value: table},
+		":zero":  &types.AttributeValueMemberN{Value: "0"},
+	}
+	leaseTerms(names, values, now.UnixMilli())
+	if prefix != "" {
+		keyCond += " AND begins_with(#lockvalue, :prefix)"
+		names["#lockvalue"] = "lockvalue"
+		values[":prefix"] = &types.AttributeValueMemberS{Value: prefix}
+	}
+	in := &dynamodb.QueryInput{
+		TableName:              aws.String(lockTable),
+		ConsistentRead:         aws.Bool(true),
+		KeyConditionExpression: aws.String(keyCond),
+		FilterExpression: aws.String("(#fence > :zero AND " +
+			"(#releasetimems >= :nowms OR (attribute_not_exists(#releasetimems) AND #releasetime >= :now))) OR " +
+			"(attribute_exists(#readers) AND #readerrelease >= :now)"),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+	}
+	if limit > 0 {
+		in.Limit = aws.Int32(limit)
+	}
+	if start != "" {
+		in.ExclusiveStartKey = map[string]types.AttributeValue{
+			"tabla":     &types.AttributeValueMemberS{Value: table},
+			"lockvalue": &types.AttributeValueMemberS{Value: start},
+		}
+	}
+	out, err := svc.Query(ctx, in)
+	if err != nil {
+		return nil, "", serviceError(err)
+	}
+	locks := make([]LockInfo, 0, len(out.Items))
+	for _, item := range out.Items {
+		locks = append(locks, lockInfo(item))
+	}
+	// La clave de LockTable es tabla + lockvalue, y tabla ya la sabemos
+	return locks, itemString(out.LastEvaluatedKey, "lockvalue"), nil
+}
@@ -0,0 +1,53 @@
+package locke
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"dynamodb/locks/locke/locketest"
+)
+
+func TestListDescribe(t *testing.T) {
+	ctx := context.Background()
+	svc := newFakeDynamo(t)
+	// Un lock que vencio hace una hora y que el TTL no ha borrado
+	old := locketest.NewFakeClock(time.Now().Add(-time.Hour))
+	acquired(t, dynamoLock(t, svc, "Ana", "Lock1", time.Minute, WithClock(old)))
+	live := acquired(t, dynamoLock(t, svc, "Pepe", "Lock1", time.Minute, WithOwner("worker-1")))
+	// Un lock compartido cuyos lectores ya salieron
+	reader := acquired(t, dynamoLock(t, svc, "Juan", "Lock1", time.Minute, WithMode(Shared)))
+	if locks, _, err := List(ctx, svc, "Usuarios", 0, ""); err != nil || len(locks) != 2 {
+		t.Fatalf("List with a reader: got %+v %v, want Juan and Pepe", locks, err)
+	}
+	if err := reader.Release(); err != nil {
+		t.Fatal(err)
+	}
+
+	locks, next, err := List(ctx, svc, "Usuarios", 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(locks) != 1 || locks[0].LockValue != "Pepe" || next != "" {
+		t.Fatalf("List got %+v next %q, want only Pepe", locks, next)
+	}
+	// Con el reloj de hace una hora Ana sigue vigente
+	locks, _, err = List(ctx, svc, "Usuarios", 0, "", WithClock(old))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(locks) != 2 || locks[0].LockValue != "Ana" {
+		t.Fatalf("List an hour ago got %+v, want Ana and Pepe", locks)
+	}
+
+	info, err := Describe(ctx, svc, "Usuarios", "Pepe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Owner != "worker-1" || info.Fence != live.Fence() || !info.Held(time.Now()) {
+		t.Fatalf("Describe got %+v", info)
+	}
+	if info, err := Describe(ctx, svc, "Usuarios", "Nadie"); err != nil || info.Held(time.Now()) || info.LockValue != "Nadie" {
+		t.Fatalf("Describe of missing lock: got %+v %v", info, err)
+	}
+}
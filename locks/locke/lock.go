@@ -1,43 +1,449 @@
 package locke
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
-
-	"github.com/aws/aws-sdk-go/service/dynamodb"
 )
 
+// Lock es un lock con lease y fencing. Las variantes *Context propagan el
+// deadline y la cancelacion del contexto a las llamadas al servicio; las
+// variantes sin contexto usan context.Background().
 type Lock interface {
 	Acquire() error
+	AcquireContext(ctx context.Context) error
+	AcquireWait(ctx context.Context, b Backoff) error
 	Release() error
+	ReleaseContext(ctx context.Context) error
 	RemainingDuration() time.Duration
 	NewDuration(time.Duration) error
+	NewDurationContext(ctx context.Context, duration time.Duration) error
 	Fence() string
 }
 
+// backend guarda el registro de los locks. Cada operacion es atomica y aplica
+// las mismas condiciones que dynamolock; las reglas locales de lease y fence
+// estan en lock. Todos los instantes (now, nrt y los de lock) son
+// milisegundos Unix.
+type backend interface {
+	// acquire registra l como holder si el lock no existe, esta liberado o
+	// vencido, y devuelve el nuevo fence. Si lo tiene otro devuelve *HeldError.
+	acquire(ctx context.Context, l *lock, now int64) (string, error)
+	// renew cambia el releasetime a nrt si l sigue siendo el holder.
+	renew(ctx context.Context, l *lock, nrt, now int64) error
+	// release pone el fence a 0 si l sigue siendo el holder.
+	release(ctx context.Context, l *lock, now int64) error
+}
+
+// lock es seguro para usar desde varias goroutines: mu protege su estado y
+// serializa las operaciones sobre el backend.
 type lock struct {
+	mu           sync.Mutex
 	fence        string
 	table        string
 	lockValue    string
 	lockType     string
 	lockName     string
+	owner        string
+	handleID     string
 	startingTime int64
 	releaseTime  int64
+	duration     time.Duration
+	reentrant    bool
+	clock        Clock
+	// holds cuenta los Acquire anidados de un lock reentrante; solo tiene
+	// sentido mientras fence != "0"
+	holds int
+	b     backend
 }
 
 const ZeroDuration time.Duration = 0
 
-func NewLock(svcType string, svc interface{}, table, lockValue, lockType string, duration time.Duration) (Lock, error) {
+// Mode es el modo de un lock "dynamo": exclusivo (escritor, por defecto) o
+// compartido (lector). Muchos lectores pueden tener el lock a la vez; un
+// escritor excluye a todos.
+type Mode int
+
+const (
+	Exclusive Mode = iota
+	Shared
+)
+
+type options struct {
+	owner        string
+	perLockFence bool
+	mode         Mode
+	writerWait   time.Duration
+	reentrant    bool
+	table        string
+	versioned    bool
+	// deadlineMargin solo lo usa NewLockContext
+	deadlineMargin time.Duration
+	clock          Clock
+}
+
+// tableName es la tabla de locks de DynamoDB elegida con WithTableName.
+func (o options) tableName() string {
+	if o.table == "" {
+		return DefaultTable
+	}
+	return o.table
+}
+
+// tableOption es la tabla de locks que eligen opts; para las funciones que
+// no crean un lock, de las que solo cuenta WithTableName.
+func tableOption(opts []Option) string {
+	return optionsOf(opts).tableName()
+}
+
+// optionsOf aplica opts sobre las opciones vacias.
+func optionsOf(opts []Option) options {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// Option configura un lock creado con NewLock.
+type Option func(*options)
+
+// WithOwner fija el identificador del holder que se guarda en el lock y se
+// exige en Release y NewDuration. Por defecto es DefaultOwner().
+func WithOwner(owner string) Option {
+	return func(o *options) {
+		o.owner = owner
+	}
+}
+
+// WithPerLockFence hace que dynamo saque el fence de un contador del propio
+// item del lock, incrementado en la misma escritura condicional que lo
+// adquiere: un solo round trip, sin el item global LockTable/LockTable y sin
+// gastar fences en intentos fallidos. Los fences crecen por recurso, no entre
+// recursos. Los backends memory y file la ignoran.
+func WithPerLockFence() Option {
+	return func(o *options) {
+		o.perLockFence = true
+	}
+}
+
+// WithMode fija el modo del lock. Shared solo lo soporta el backend dynamo.
+func WithMode(mode Mode) Option {
+	return func(o *options) {
+		o.mode = mode
+	}
+}
+
+// WithWriterPreference evita que los lectores dejen sin turno a un escritor:
+// cuando un escritor no entra por haber lectores, durante window no se
+// admiten lectores nuevos. El escritor renueva la espera en cada intento
+// (por ejemplo con AcquireWait).
+func WithWriterPreference(window time.Duration) Option {
+	return func(o *options) {
+		o.writerWait = window
+	}
+}
+
+// WithReentrant permite que el handle que ya tiene el lock vuelva a hacer
+// Acquire: cada Acquire anidado suma uno a la cuenta y solo el ultimo Release
+// libera el lock. Sin ella, Acquire sobre un lock ya adquirido devuelve
+// ErrAlreadyHeld.
+func WithReentrant() Option {
+	return func(o *options) {
+		o.reentrant = true
+	}
+}
+
+// WithTableName guarda los locks "dynamo" en la tabla name en lugar de
+// DefaultTable. La tabla se crea o comprueba con EnsureTable/ValidateTable.
+// Los backends "memory" y "file" la ignoran.
+func WithTableName(name string) Option {
+	return func(o *options) {
+		o.table = name
+	}
+}
+
+// WithVersionLease hace que el vencimiento de los locks "dynamo" no dependa
+// de que los relojes de los hosts coincidan: el que espera solo toma el lock
+// cuando ha visto, con su propio reloj monotono, que la version del registro
+// no cambia durante todo el lease del holder. Acquire hace una lectura mas, y
+// hay que reintentar (AcquireWait) para tomar un lock abandonado. No se
+// combina con el modo compartido, AcquireAll ni Steal.
+func WithVersionLease() Option {
+	return func(o *options) {
+		o.versioned = true
+	}
+}
+
+// DefaultOwner identifica al proceso: el log stream en Lambda, si no host/pid.
+func DefaultOwner() string {
+	if ls := os.Getenv("AWS_LAMBDA_LOG_STREAM_NAME"); ls != "" {
+		return ls
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return host + "/" + strconv.Itoa(os.Getpid())
+}
+
+// NewLock crea un lock sobre table/lockValue con un lease que vence duration
+// despues de crearlo. svcType elige el backend:
+//   - "dynamo": svc es un Client (por ejemplo *dynamodb.Client).
+//   - "memory": svc es un *MemoryStore, o nil para el store del proceso.
+//   - "file": svc es el directorio (string) donde se guardan los locks.
+func NewLock(svcType string, svc interface{}, table, lockValue, lockType string, duration time.Duration, opts ...Option) (Lock, error) {
+	o := options{owner: DefaultOwner()}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.mode == Shared && svcType != "dynamo" {
+		return nil, fmt.Errorf("error: shared mode not supported by %s lock service", svcType)
+	}
+	if o.versioned && (svcType != "dynamo" || o.mode == Shared) {
+		return nil, errors.New("error: version leases need exclusive dynamo locks")
+	}
 	var err error
-	var lo Lock
+	var b backend
 	switch svcType {
 	case "dynamo":
-		lo, err = newDynamoLock(svc.(*dynamodb.DynamoDB), table, lockValue, lockType, duration)
+		client, ok := svc.(Client)
+		if !ok {
+			return nil, fmt.Errorf("error: dynamo lock service must be a locke.Client, got %T", svc)
+		}
+		b, err = newDynamoLock(client, o)
+	case "memory":
+		store, ok := svc.(*MemoryStore)
+		if !ok && svc != nil {
+			return nil, fmt.Errorf("error: memory lock service must be a *locke.MemoryStore, got %T", svc)
+		}
+		b, err = newMemoryLock(store)
+	case "file":
+		dir, ok := svc.(string)
+		if !ok {
+			return nil, fmt.Errorf("error: file lock service must be a directory, got %T", svc)
+		}
+		b, err = newFileLock(dir)
 	default:
-		return nil, errors.New("error: Unknown lock service")
+		return nil, ErrUnknownService
 	}
 	if err != nil {
 		return nil, err
 	}
-	return lo, nil
+	clock := o.timeSource()
+	now := clock.Now().UTC()
+	return &lock{
+		fence:        "0",
+		table:        table,
+		lockValue:    lockValue,
+		lockType:     lockType,
+		lockName:     strings.Join([]string{lockValue, lockType}, "->"),
+		owner:        o.owner,
+		handleID:     newHandleID(),
+		startingTime: now.UnixMilli(),
+		releaseTime:  now.Add(duration).UnixMilli(),
+		duration:     duration,
+		reentrant:    o.reentrant,
+		clock:        clock,
+		b:            b,
+	}, nil
+}
+
+// newHandleID identifica un handle dentro de su owner.
+func newHandleID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b)
+}
+
+func (l *lock) Acquire() error {
+	return l.AcquireContext(context.Background())
+}
+
+// AcquireContext hace un intento de obtener el lock. Si lo tiene otro devuelve
+// un *HeldError con los datos del holder actual.
+func (l *lock) AcquireContext(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.clock.Now().UTC().UnixMilli()
+	// Ya vencio el lock
+	if l.releaseTime <= now {
+		l.fence = "0"
+		return ErrExpired
+	}
+	// Ya tiene adquirido el lock
+	if l.fence != "0" {
+		if !l.reentrant {
+			return ErrAlreadyHeld
+		}
+		l.holds++
+		return nil
+	}
+	fence, err := l.b.acquire(ctx, l, now)
+	// Si se obtuvo el lock registrar el fence
+	if err == nil {
+		l.fence = fence
+		l.holds = 1
+	}
+	return err
+}
+
+// AcquireWait reintenta Acquire mientras el lock este tomado por otro, hasta que
+// se cancele ctx. Mientras no tiene el lock, el lease del handle empieza de
+// nuevo en cada intento, asi que la espera no lo hace vencer. La espera entre
+// intentos la decide b, sin pasarse del releasetime del holder actual.
+func (l *lock) AcquireWait(ctx context.Context, b Backoff) error {
+	return retryHeld(ctx, l.clock, b, func(ctx context.Context) error {
+		l.restartLease()
+		return l.AcquireContext(ctx)
+	})
+}
+
+// restartLease empieza ahora el lease de un handle que no tiene el lock.
+func (l *lock) restartLease() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.fence != "0" {
+		return
+	}
+	now := l.clock.Now().UTC()
+	l.startingTime = now.UnixMilli()
+	l.releaseTime = now.Add(l.duration).UnixMilli()
+}
+
+// retryHeld llama a try mientras devuelva un *HeldError, esperando entre
+// intentos lo que diga b sin pasarse del ReleaseTime del holder.
+func retryHeld(ctx context.Context, clock Clock, b Backoff, try func(ctx context.Context) error) error {
+	if b == nil {
+		b = DefaultBackoff
+	}
+	var delay time.Duration
+	for attempt := 0; ; attempt++ {
+		err := try(ctx)
+		var held *HeldError
+		if err == nil || !errors.As(err, &held) {
+			return err
+		}
+		delay = b.Next(attempt, delay)
+		wait := delay
+		// El lock queda libre cuando now > releasetime del holder, un
+		// milisegundo despues: es la precision de los leases
+		if !held.ReleaseTime.IsZero() {
+			if untilFree := held.ReleaseTime.Add(time.Millisecond).Sub(clock.Now()); untilFree < wait {
+				wait = untilFree
+			}
+		}
+		if wait < minBackoff {
+			wait = minBackoff
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-clock.After(wait):
+		}
+	}
+}
+
+func (l *lock) NewDuration(duration time.Duration) error {
+	return l.NewDurationContext(context.Background(), duration)
+}
+
+func (l *lock) NewDurationContext(ctx context.Context, duration time.Duration) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// Lock no adquirido no se puede cambiar duracion
+	if l.fence == "0" {
+		return ErrNotAcquired
+	}
+	now := l.clock.Now().UTC()
+	// Lock expirado no se puede cambiar duracion
+	if l.releaseTime <= now.UnixMilli() {
+		l.fence = "0"
+		return ErrExpired
+	}
+	nrt := now.Add(duration).UnixMilli()
+	err := l.lost(l.b.renew(ctx, l, nrt, now.UnixMilli()))
+	if err == nil {
+		l.releaseTime = nrt
+		l.duration = duration
+	}
+	return err
+}
+
+func (l *lock) Release() error {
+	return l.ReleaseContext(context.Background())
+}
+
+func (l *lock) ReleaseContext(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// Lock no adquirido no se puede hacer release
+	if l.fence == "0" {
+		return ErrNotAcquired
+	}
+	now := l.clock.Now().UTC().UnixMilli()
+	// Lock expirado no se puede hacer release
+	// No es necesario ir a la base de datos, "confiamos" en el reloj de lambda y
+	// los problemas se evitan mediante fencing
+	if l.releaseTime <= now {
+		l.fence = "0"
+		return ErrExpired
+	}
+	// Release anidado de un lock reentrante, lo sigue teniendo el Acquire de fuera
+	if l.reentrant && l.holds > 1 {
+		l.holds--
+		return nil
+	}
+	err := l.lost(l.b.release(ctx, l, now))
+	if err == nil {
+		l.fence = "0"
+	}
+	return err
+}
+
+// lost marca el handle como no adquirido si el backend dice que el registro ya
+// no es suyo o que su lease vencio. Se llama con l.mu tomado.
+func (l *lock) lost(err error) error {
+	if errors.Is(err, ErrNotOwner) || errors.Is(err, ErrExpired) {
+		l.fence = "0"
+	}
+	return err
+}
+
+func (l *lock) RemainingDuration() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// Lock no aquirido
+	if l.fence == "0" {
+		return ZeroDuration
+	}
+	now := l.clock.Now().UTC().UnixMilli()
+	// Lock expirado
+	if l.releaseTime <= now {
+		l.fence = "0"
+		return ZeroDuration
+	}
+	// Calcular tiempo restante en milisegundos
+	return time.Duration(l.releaseTime-now) * time.Millisecond
+}
+
+func (l *lock) Fence() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.clock.Now().UTC().UnixMilli()
+	// Lock expirado, cuando se vaya escribir en la base de datos
+	// le evita chequear la consistencia mediante el fencing
+	if l.releaseTime <= now {
+		l.fence = "0"
+	}
+	return l.fence
 }
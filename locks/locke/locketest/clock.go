@@ -0,0 +1,90 @@
+// Package locketest ayuda a probar codigo que usa locke sin esperar a que
+// pasen los leases de verdad.
+package locketest
+
+import (
+	"sync"
+	"time"
+)
+
+// FakeClock es un locke.Clock que solo avanza con Advance o Set. Los canales
+// de After se disparan cuando el reloj llega a su instante.
+type FakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []waiter
+}
+
+type waiter struct {
+	at time.Time
+	ch chan time.Time
+}
+
+// NewFakeClock crea un reloj parado en now.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.waiters = append(c.waiters, waiter{at: c.now.Add(d), ch: ch})
+	return ch
+}
+
+// Advance adelanta el reloj d.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.set(c.now.Add(d))
+}
+
+// Set pone el reloj en t, que no puede ser anterior al instante actual.
+func (c *FakeClock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t.Before(c.now) {
+		panic("locketest: FakeClock can not go back in time")
+	}
+	c.set(t)
+}
+
+// BlockUntil espera a que haya al menos n After pendientes, para avanzar el
+// reloj sabiendo que las goroutines bajo prueba ya estan esperando.
+func (c *FakeClock) BlockUntil(n int) {
+	for c.Pending() < n {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Pending es el numero de After que aun no se han disparado.
+func (c *FakeClock) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.waiters)
+}
+
+// set mueve el reloj y dispara los After vencidos; con c.mu tomado.
+func (c *FakeClock) set(t time.Time) {
+	c.now = t
+	pending := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.at.After(t) {
+			pending = append(pending, w)
+			continue
+		}
+		w.ch <- t
+	}
+	c.waiters = pending
+}
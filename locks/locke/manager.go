@@ -0,0 +1,250 @@
+package locke
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+)
+
+// DefaultManagerTick es cada cuanto revisa LockManager los leases si no se
+// indica otro periodo.
+const DefaultManagerTick = time.Second
+
+// LockManager crea locks con NewLock y lleva el registro de los handles vivos
+// del proceso: los renueva juntos y los libera todos con Close, por ejemplo al
+// recibir SIGTERM (CloseOnSignal), para que otros workers no esperen el lease
+// completo. Cada handle se renueva con su duration original cuando le queda
+// menos de la mitad. Un handle sale del registro al liberarlo con su Release
+// o al perder el lease, y vuelve a entrar si se adquiere otra vez.
+type LockManager struct {
+	svcType string
+	svc     interface{}
+	opts    []Option
+
+	mu     sync.Mutex
+	locks  map[*managedLock]time.Duration
+	closed bool
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// managedLock es un Lock del registro de un LockManager.
+type managedLock struct {
+	Lock
+	m        *LockManager
+	duration time.Duration
+}
+
+// NewLockManager crea un manager cuyos locks usan svcType/svc y opts, como
+// NewLock. tick es cada cuanto revisa los leases; si es 0, DefaultManagerTick.
+func NewLockManager(svcType string, svc interface{}, tick time.Duration, opts ...Option) *LockManager {
+	if tick <= 0 {
+		tick = DefaultManagerTick
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &LockManager{
+		svcType: svcType,
+		svc:     svc,
+		opts:    opts,
+		locks:   map[*managedLock]time.Duration{},
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+	go m.renewLoop(ctx, tick)
+	return m
+}
+
+// NewLock crea un lock con NewLock y lo registra. opts se anaden a los del
+// manager.
+func (m *LockManager) NewLock(table, lockValue, lockType string, duration time.Duration, opts ...Option) (Lock, error) {
+	all := append(append([]Option{}, m.opts...), opts...)
+	l, err := NewLock(m.svcType, m.svc, table, lockValue, lockType, duration, all...)
+	if err != nil {
+		return nil, err
+	}
+	ml := &managedLock{Lock: l, m: m, duration: duration}
+	if err := m.register(ml); err != nil {
+		return nil, err
+	}
+	return ml, nil
+}
+
+// register anade ml al registro, salvo que el manager este cerrado.
+func (m *LockManager) register(ml *managedLock) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return errors.New("error: lock manager closed")
+	}
+	m.locks[ml] = ml.duration
+	return nil
+}
+
+// asLock devuelve el *lock de l, tambien si es de un LockManager.
+func asLock(l Lock) (*lock, bool) {
+	if ml, ok := l.(*managedLock); ok {
+		l = ml.Lock
+	}
+	lo, ok := l.(*lock)
+	return lo, ok
+}
+
+func (ml *managedLock) Acquire() error {
+	return ml.AcquireContext(context.Background())
+}
+
+func (ml *managedLock) AcquireContext(ctx context.Context) error {
+	return ml.reacquired(ctx, ml.Lock.AcquireContext(ctx))
+}
+
+func (ml *managedLock) AcquireWait(ctx context.Context, b Backoff) error {
+	return ml.reacquired(ctx, ml.Lock.AcquireWait(ctx, b))
+}
+
+// reacquired vuelve a registrar ml tras un Acquire sin error, porque el
+// Release anterior lo saco del registro. Si el manager ya esta cerrado no se
+// queda con el lock.
+func (ml *managedLock) reacquired(ctx context.Context, err error) error {
+	if err != nil {
+		return err
+	}
+	if err := ml.m.register(ml); err != nil {
+		return errors.Join(err, ml.Lock.ReleaseContext(ctx))
+	}
+	return nil
+}
+
+func (ml *managedLock) Release() error {
+	return ml.ReleaseContext(context.Background())
+}
+
+func (ml *managedLock) ReleaseContext(ctx context.Context) error {
+	err := ml.Lock.ReleaseContext(ctx)
+	// Un reentrante sigue adquirido tras un Release anidado
+	if ml.Lock.Fence() == "0" {
+		ml.m.forget(ml)
+	}
+	return err
+}
+
+func (m *LockManager) forget(ml *managedLock) {
+	m.mu.Lock()
+	delete(m.locks, ml)
+	m.mu.Unlock()
+}
+
+// Len es el numero de handles registrados.
+func (m *LockManager) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.locks)
+}
+
+// snapshot copia el registro para trabajar sin tener el mutex.
+func (m *LockManager) snapshot() map[*managedLock]time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	locks := make(map[*managedLock]time.Duration, len(m.locks))
+	for ml, d := range m.locks {
+		locks[ml] = d
+	}
+	return locks
+}
+
+func (m *LockManager) renewLoop(ctx context.Context, tick time.Duration) {
+	defer close(m.done)
+	clock := optionsOf(m.opts).timeSource()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-clock.After(tick):
+		}
+		var wg sync.WaitGroup
+		for ml, duration := range m.snapshot() {
+			// Sin adquirir todavia, o con lease de sobra
+			if ml.Lock.Fence() == "0" || ml.Lock.RemainingDuration() > duration/2 {
+				continue
+			}
+			wg.Add(1)
+			go func(ml *managedLock, duration time.Duration) {
+				defer wg.Done()
+				err := ml.Lock.NewDurationContext(ctx, duration)
+				if errors.Is(err, ErrNotOwner) || errors.Is(err, ErrExpired) {
+					ml.m.forget(ml)
+				}
+			}(ml, duration)
+		}
+		wg.Wait()
+	}
+}
+
+// Close deja de renovar y libera a la vez todos los handles adquiridos del
+// registro, esperando como mucho hasta que ctx termine. Devuelve juntos los
+// errores de Release, y el de ctx si no terminaron a tiempo. Despues de Close
+// el manager no crea locks.
+func (m *LockManager) Close(ctx context.Context) error {
+	m.mu.Lock()
+	m.closed = true
+	m.mu.Unlock()
+	m.cancel()
+	<-m.done
+	locks := m.snapshot()
+	errs := make(chan error, len(locks))
+	for ml := range locks {
+		go func(ml *managedLock) {
+			var err error
+			// Todos los Acquire anidados de un reentrante
+			for err == nil && ml.Lock.Fence() != "0" {
+				err = ml.Lock.ReleaseContext(ctx)
+			}
+			m.forget(ml)
+			errs <- err
+		}(ml)
+	}
+	var all []error
+	for range locks {
+		select {
+		case err := <-errs:
+			all = append(all, err)
+		case <-ctx.Done():
+			return errors.Join(append(all, ctx.Err())...)
+		}
+	}
+	return errors.Join(all...)
+}
+
+// CloseOnSignal hace Close, con timeout, al recibir una de sigs, y despues
+// vuelve a enviar la senal al proceso para que siga su curso (normalmente
+// terminar). Devuelve la funcion que deja de escuchar.
+func (m *LockManager) CloseOnSignal(timeout time.Duration, sigs ...os.Signal) (stop func()) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sigs...)
+	quit := make(chan struct{})
+	var once sync.Once
+	stop = func() {
+		once.Do(func() {
+			signal.Stop(ch)
+			close(quit)
+		})
+	}
+	go func() {
+		select {
+		case <-quit:
+			return
+		case sig := <-ch:
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			m.Close(ctx)
+			cancel()
+			stop()
+			if p, err := os.FindProcess(os.Getpid()); err == nil {
+				p.Signal(sig)
+			}
+		}
+	}()
+	return stop
+}
@@ -0,0 +1,128 @@
+package locke
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// MemoryStore guarda locks en memoria del proceso. Es seguro usarlo desde
+// varias goroutines; los locks que comparten store se excluyen entre si.
+type MemoryStore struct {
+	mu      sync.Mutex
+	fence   int64
+	records map[memoryKey]*memoryRecord
+}
+
+type memoryKey struct {
+	table     string
+	lockValue string
+}
+
+// memoryRecord es el equivalente del item de LockTable.
+type memoryRecord struct {
+	fence        string
+	owner        string
+	lockName     string
+	lockType     string
+	startingTime int64
+	releaseTime  int64
+}
+
+// NewMemoryStore crea un store vacio.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: map[memoryKey]*memoryRecord{}}
+}
+
+// defaultMemoryStore se usa cuando NewLock("memory", nil, ...).
+var defaultMemoryStore = NewMemoryStore()
+
+type memorylock struct {
+	store *MemoryStore
+}
+
+func newMemoryLock(store *MemoryStore) (backend, error) {
+	if store == nil {
+		store = defaultMemoryStore
+	}
+	return &memorylock{store: store}, nil
+}
+
+func (m *memorylock) acquire(ctx context.Context, l *lock, now int64) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	s := m.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := memoryKey{table: l.table, lockValue: l.lockValue}
+	if rec := s.records[key]; rec != nil && rec.fence != "0" && rec.releaseTime >= now {
+		return "", rec.held(l)
+	}
+	s.fence++
+	fence := strconv.FormatInt(s.fence, 10)
+	s.records[key] = &memoryRecord{
+		fence:        fence,
+		owner:        l.owner,
+		lockName:     l.lockName,
+		lockType:     l.lockType,
+		startingTime: l.startingTime,
+		releaseTime:  l.releaseTime,
+	}
+	return fence, nil
+}
+
+func (m *memorylock) renew(ctx context.Context, l *lock, nrt, now int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s := m.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, err := s.owned(l, now)
+	if err != nil {
+		return err
+	}
+	rec.releaseTime = nrt
+	return nil
+}
+
+func (m *memorylock) release(ctx context.Context, l *lock, now int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s := m.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, err := s.owned(l, now)
+	if err != nil {
+		return err
+	}
+	rec.fence = "0"
+	return nil
+}
+
+// owned devuelve el registro de l si sigue siendo suyo y no vencio. Se llama
+// con s.mu tomado.
+func (s *MemoryStore) owned(l *lock, now int64) (*memoryRecord, error) {
+	rec := s.records[memoryKey{table: l.table, lockValue: l.lockValue}]
+	if rec == nil || rec.fence != l.fence || rec.owner != l.owner {
+		return nil, ErrNotOwner
+	}
+	if rec.releaseTime <= now {
+		return nil, ErrExpired
+	}
+	return rec, nil
+}
+
+func (rec *memoryRecord) held(l *lock) *HeldError {
+	return &HeldError{
+		Table:       l.table,
+		LockValue:   l.lockValue,
+		LockName:    rec.lockName,
+		LockType:    rec.lockType,
+		Fence:       rec.fence,
+		ReleaseTime: time.UnixMilli(rec.releaseTime).UTC(),
+	}
+}
@@ -0,0 +1,224 @@
+package locke
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Resource identifica un lock de LockTable.
+type Resource struct {
+	Table     string
+	LockValue string
+	LockType  string
+}
+
+// AcquireAll adquiere en exclusivo todos los resources en un solo
+// TransactWriteItems: o se obtienen todos o ninguno, sin riesgo de deadlock
+// ni de quedarse con una parte. Devuelve un Lock "dynamo" por resource, en el
+// mismo orden, con un lease de duration. Si alguno lo tiene otro devuelve el
+// *HeldError del primero que fallo. Caben como mucho MaxTransactItems. Con
+// WithPerLockFence el fence de cada lock se lee despues de la transaccion; si
+// esa lectura falla, AcquireAll libera los que pudo leer y devuelve el error.
+func AcquireAll(ctx context.Context, svc Client, resources []Resource, duration time.Duration, opts ...Option) ([]Lock, error) {
+	if len(resources) == 0 || len(resources) > MaxTransactItems {
+		return nil, fmt.Errorf("error: AcquireAll needs between 1 and %d resources", MaxTransactItems)
+	}
+	locks := make([]*lock, len(resources))
+	seen := map[Resource]bool{}
+	for i, r := range resources {
+		key := Resource{Table: r.Table, LockValue: r.LockValue}
+		if seen[key] {
+			return nil, fmt.Errorf("error: resource %s/%s repeated", r.Table, r.LockValue)
+		}
+		seen[key] = true
+		l, err := NewLock("dynamo", svc, r.Table, r.LockValue, r.LockType, duration, opts...)
+		if err != nil {
+			return nil, err
+		}
+		locks[i] = l.(*lock)
+	}
+	d := locks[0].b.(*dynamolock)
+	if d.shared || d.versioned {
+		return nil, errors.New("error: AcquireAll only takes exclusive locks without version leases")
+	}
+	now := locks[0].clock.Now().UTC().UnixMilli()
+	if locks[0].releaseTime <= now {
+		return nil, ErrExpired
+	}
+	// Con el fencing global se reservan todos los fences de una vez
+	fences := make([]string, len(locks))
+	if !d.perLockFence {
+		last, err := d.nextFence(ctx, len(locks))
+		if err != nil {
+			return nil, err
+		}
+		for i := range fences {
+			fences[i] = strconv.FormatInt(last-int64(len(locks)-1-i), 10)
+		}
+	}
+	items := make([]types.TransactWriteItem, len(locks))
+	for i, l := range locks {
+		items[i] = transactUpdate(d.acquireInput(l, now, fences[i]))
+	}
+	_, err := svc.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: items})
+	if err != nil {
+		var tce *types.TransactionCanceledException
+		if errors.As(err, &tce) && len(tce.CancellationReasons) == len(locks) {
+			for i, reason := range tce.CancellationReasons {
+				if aws.ToString(reason.Code) == "ConditionalCheckFailed" {
+					return nil, heldError(locks[i], reason.Item, err, false)
+				}
+			}
+		}
+		return nil, serviceError(err)
+	}
+	res := make([]Lock, 0, len(locks))
+	var errs []error
+	for i, l := range locks {
+		// Una transaccion no devuelve valores: el fence del contador del lock
+		// se lee despues
+		if fences[i] == "" {
+			gio, err := svc.GetItem(ctx, &dynamodb.GetItemInput{
+				TableName:      aws.String(d.lockTable),
+				Key:            lockKey(l),
+				ConsistentRead: aws.Bool(true),
+			})
+			if err != nil {
+				errs = append(errs, serviceError(err))
+				continue
+			}
+			if itemString(gio.Item, "owner") != l.owner {
+				errs = append(errs, ErrNotOwner)
+				continue
+			}
+			fences[i] = itemNumber(gio.Item, "fence")
+		}
+		l.fence = fences[i]
+		l.holds = 1
+		res = append(res, l)
+	}
+	if len(errs) > 0 {
+		// Ya estan todos tomados: se sueltan los que tienen fence conocido, y
+		// los demas vencen con su lease
+		rctx, rcancel := context.WithTimeout(context.WithoutCancel(ctx), releaseTimeout)
+		defer rcancel()
+		return nil, errors.Join(append(errs, ReleaseAll(rctx, res...))...)
+	}
+	return res, nil
+}
+
+// ReleaseAll libera juntos, en un TransactWriteItems, locks exclusivos
+// "dynamo" adquiridos del mismo cliente y tabla de locks. Como Release, a un
+// lock reentrante con Acquire anidados solo le quita uno, y uno con
+// WithVersionLease solo se libera si la version sigue siendo la suya. Si
+// alguno ya no es nuestro, libera el resto uno a uno y devuelve todos los
+// errores.
+func ReleaseAll(ctx context.Context, locks ...Lock) error {
+	if len(locks) == 0 {
+		return nil
+	}
+	if len(locks) > MaxTransactItems {
+		return fmt.Errorf("error: ReleaseAll takes at most %d locks", MaxTransactItems)
+	}
+	ls := make([]*lock, len(locks))
+	var first *dynamolock
+	for i, lo := range locks {
+		l, ok := asLock(lo)
+		if !ok {
+			return errors.New("error: ReleaseAll needs dynamo locks")
+		}
+		d, ok := l.b.(*dynamolock)
+		if !ok || d.shared {
+			return errors.New("error: ReleaseAll needs exclusive dynamo locks")
+		}
+		// La transaccion va a un cliente y a una tabla de locks
+		if first == nil {
+			first = d
+		} else if d.svc != first.svc || d.lockTable != first.lockTable {
+			return errors.New("error: ReleaseAll needs locks of the same client and lock table")
+		}
+		if l.Fence() == "0" {
+			return fmt.Errorf("error: lock %s/%s: %w", l.table, l.lockValue, ErrNotAcquired)
+		}
+		ls[i] = l
+	}
+	var items []types.TransactWriteItem
+	var released []*lock
+	for _, l := range ls {
+		l.mu.Lock()
+		// Release anidado de un lock reentrante, lo sigue teniendo el Acquire de fuera
+		if l.reentrant && l.holds > 1 {
+			l.holds--
+			l.mu.Unlock()
+			continue
+		}
+		items = append(items, transactUpdate(l.b.(*dynamolock).releaseInput(l, l.clock.Now().UTC().UnixMilli())))
+		l.mu.Unlock()
+		released = append(released, l)
+	}
+	if len(items) == 0 {
+		return nil
+	}
+	_, err := first.svc.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: items})
+	if err == nil {
+		for _, l := range released {
+			l.mu.Lock()
+			l.fence = "0"
+			l.mu.Unlock()
+		}
+		return nil
+	}
+	var tce *types.TransactionCanceledException
+	if !errors.As(err, &tce) || len(tce.CancellationReasons) != len(released) {
+		return serviceError(err)
+	}
+	var errs []error
+	for i, reason := range tce.CancellationReasons {
+		if aws.ToString(reason.Code) == "ConditionalCheckFailed" {
+			l := released[i]
+			l.mu.Lock()
+			lerr := l.lost(recordError(l, reason.Item, err))
+			l.mu.Unlock()
+			errs = append(errs, fmt.Errorf("error: lock %s/%s: %w", l.table, l.lockValue, lerr))
+		}
+	}
+	for _, l := range released {
+		if l.Fence() == "0" {
+			continue
+		}
+		if err := l.ReleaseContext(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("error: lock %s/%s: %w", l.table, l.lockValue, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// transactUpdate pasa un UpdateItem a una accion de TransactWriteItems.
+func transactUpdate(in *dynamodb.UpdateItemInput) types.TransactWriteItem {
+	return types.TransactWriteItem{
+		Update: &types.Update{
+			TableName:                           in.TableName,
+			Key:                                 in.Key,
+			ConditionExpression:                 in.ConditionExpression,
+			ExpressionAttributeNames:            in.ExpressionAttributeNames,
+			ExpressionAttributeValues:           in.ExpressionAttributeValues,
+			UpdateExpression:                    in.UpdateExpression,
+			ReturnValuesOnConditionCheckFailure: in.ReturnValuesOnConditionCheckFailure,
+		},
+	}
+}
+
+// lockKey es la clave del item de l en la tabla de locks.
+func lockKey(l *lock) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"tabla":     &types.AttributeValueMemberS{Value: l.table},
+		"lockvalue": &types.AttributeValueMemberS{Value: l.lockValue},
+	}
+}
@@ -0,0 +1,218 @@
+package locke
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+var multiResources = []Resource{
+	{Table: "Usuarios", LockValue: "Pepe", LockType: "Lock1"},
+	{Table: "Usuarios", LockValue: "Juan", LockType: "Lock1"},
+	{Table: "Pedidos", LockValue: "1", LockType: "Lock1"},
+}
+
+// heldBy indica si otro handle no puede adquirir r.
+func heldBy(t *testing.T, svc Client, r Resource) bool {
+	t.Helper()
+	l, err := NewLock("dynamo", svc, r.Table, r.LockValue, "Otro", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = l.Acquire()
+	if err == nil {
+		if err := l.Release(); err != nil {
+			t.Fatal(err)
+		}
+		return false
+	}
+	if !errors.Is(err, ErrHeld) {
+		t.Fatal(err)
+	}
+	return true
+}
+
+func TestAcquireAll(t *testing.T) {
+	for name, opts := range map[string][]Option{"global-fence": nil, "per-lock-fence": {WithPerLockFence()}} {
+		opts := opts
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			svc := newFakeDynamo(t)
+			if _, err := AcquireAll(ctx, svc, nil, time.Minute, opts...); err == nil {
+				t.Fatal("AcquireAll without resources succeeded")
+			}
+			if _, err := AcquireAll(ctx, svc, append(multiResources, multiResources[0]), time.Minute, opts...); err == nil {
+				t.Fatal("AcquireAll with a repeated resource succeeded")
+			}
+			locks, err := AcquireAll(ctx, svc, multiResources, time.Minute, opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fences := map[string]bool{}
+			for i, l := range locks {
+				fences[l.Fence()] = true
+				if fenceNumber(t, l) <= 0 || !heldBy(t, svc, multiResources[i]) {
+					t.Fatalf("resource %d not acquired, fence %s", i, l.Fence())
+				}
+			}
+			if len(fences) != len(locks) {
+				t.Fatalf("repeated fences %v", fences)
+			}
+			if err := ReleaseAll(ctx, locks...); err != nil {
+				t.Fatal(err)
+			}
+			for i, l := range locks {
+				if l.Fence() != "0" || heldBy(t, svc, multiResources[i]) {
+					t.Fatalf("resource %d not released", i)
+				}
+			}
+
+			// Si uno lo tiene otro no se adquiere ninguno
+			acquired(t, dynamoLock(t, svc, "Juan", "Otro", time.Minute))
+			var held *HeldError
+			if _, err := AcquireAll(ctx, svc, multiResources, time.Minute, opts...); !errors.As(err, &held) || held.LockValue != "Juan" {
+				t.Fatalf("AcquireAll with Juan held: got %v, want *HeldError for Juan", err)
+			}
+			if heldBy(t, svc, multiResources[0]) || heldBy(t, svc, multiResources[2]) {
+				t.Fatal("failed AcquireAll kept part of the locks")
+			}
+		})
+	}
+}
+
+func TestReleaseAllReentrant(t *testing.T) {
+	ctx := context.Background()
+	svc := newFakeDynamo(t)
+	locks, err := AcquireAll(ctx, svc, multiResources, time.Minute, WithReentrant())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := locks[0].Acquire(); err != nil {
+		t.Fatal(err)
+	}
+	fence := locks[0].Fence()
+	// Al reentrante solo se le quita el Acquire anidado
+	if err := ReleaseAll(ctx, locks...); err != nil {
+		t.Fatal(err)
+	}
+	if locks[0].Fence() != fence || !heldBy(t, svc, multiResources[0]) {
+		t.Fatalf("nested hold released, fence %s", locks[0].Fence())
+	}
+	if heldBy(t, svc, multiResources[1]) || heldBy(t, svc, multiResources[2]) {
+		t.Fatal("single holds not released")
+	}
+	if err := ReleaseAll(ctx, locks[0]); err != nil {
+		t.Fatal(err)
+	}
+	if heldBy(t, svc, multiResources[0]) {
+		t.Fatal("outer hold not released")
+	}
+}
+
+func TestReleaseAllVersioned(t *testing.T) {
+	ctx := context.Background()
+	svc := newFakeDynamo(t)
+	var locks []Lock
+	for _, r := range multiResources[:2] {
+		l, err := NewLock("dynamo", svc, r.Table, r.LockValue, r.LockType, time.Minute, WithVersionLease())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := l.Acquire(); err != nil {
+			t.Fatal(err)
+		}
+		locks = append(locks, l)
+	}
+	// Otra version en el registro de Juan: no se libera
+	_, err := svc.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(DefaultTable),
+		Key:                       map[string]types.AttributeValue{"tabla": &types.AttributeValueMemberS{Value: "Usuarios"}, "lockvalue": &types.AttributeValueMemberS{Value: "Juan"}},
+		UpdateExpression:          aws.String("SET #rvn = :rvn"),
+		ExpressionAttributeNames:  map[string]string{"#rvn": "rvn"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{":rvn": &types.AttributeValueMemberS{Value: "otra"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ReleaseAll(ctx, locks...); err == nil {
+		t.Fatal("ReleaseAll released a lock with another version")
+	}
+	if info, err := Describe(ctx, svc, "Usuarios", "Juan"); err != nil || info.Fence == "0" {
+		t.Fatalf("lock with another version released: %+v %v", info, err)
+	}
+	if locks[0].Fence() != "0" || heldBy(t, svc, multiResources[0]) {
+		t.Fatal("lock with its own version not released")
+	}
+}
+
+func TestReleaseAllErrors(t *testing.T) {
+	ctx := context.Background()
+	svc := newFakeDynamo(t)
+	if err := EnsureTable(ctx, svc, "OtraLockTable"); err != nil {
+		t.Fatal(err)
+	}
+	pepe := acquired(t, dynamoLock(t, svc, "Pepe", "Lock1", time.Minute))
+	juan := acquired(t, dynamoLock(t, svc, "Juan", "Lock1", time.Minute, WithTableName("OtraLockTable")))
+	if err := ReleaseAll(ctx, pepe, juan); err == nil {
+		t.Fatal("ReleaseAll with two lock tables succeeded")
+	}
+	if pepe.Fence() == "0" || juan.Fence() == "0" {
+		t.Fatal("rejected ReleaseAll changed the handles")
+	}
+	mem, err := NewLock("memory", NewMemoryStore(), "Usuarios", "Ana", "Lock1", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ReleaseAll(ctx, pepe, mem); err == nil {
+		t.Fatal("ReleaseAll with a memory lock succeeded")
+	}
+
+	// Si uno ya no es nuestro se liberan los demas
+	ana := acquired(t, dynamoLock(t, svc, "Ana", "Lock1", time.Minute))
+	if _, err := ForceRelease(ctx, svc, "Usuarios", "Ana"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ReleaseAll(ctx, pepe, ana); !errors.Is(err, ErrNotOwner) {
+		t.Fatalf("ReleaseAll with a lost lock: got %v, want ErrNotOwner", err)
+	}
+	if pepe.Fence() != "0" || ana.Fence() != "0" {
+		t.Fatalf("fences %s and %s after ReleaseAll", pepe.Fence(), ana.Fence())
+	}
+	if heldBy(t, svc, Resource{Table: "Usuarios", LockValue: "Pepe"}) {
+		t.Fatal("lock still held after ReleaseAll")
+	}
+}
+
+// getFailingClient hace fallar el GetItem del lock lockValue.
+type getFailingClient struct {
+	Client
+	lockValue string
+}
+
+func (c *getFailingClient) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	if v, ok := params.Key["lockvalue"].(*types.AttributeValueMemberS); ok && v.Value == c.lockValue {
+		return nil, errors.New("boom")
+	}
+	return c.Client.GetItem(ctx, params, optFns...)
+}
+
+func TestAcquireAllFenceReadFails(t *testing.T) {
+	svc := newFakeDynamo(t)
+	failing := &getFailingClient{Client: svc, lockValue: "Juan"}
+	locks, err := AcquireAll(context.Background(), failing, multiResources, time.Minute, WithPerLockFence())
+	if err == nil || locks != nil {
+		t.Fatalf("got %v %v, want an error and no locks", locks, err)
+	}
+	// Los que pudo leer quedan libres; Juan vence con su lease
+	if heldBy(t, svc, multiResources[0]) || heldBy(t, svc, multiResources[2]) {
+		t.Fatal("locks with a known fence not released")
+	}
+	if !heldBy(t, svc, multiResources[1]) {
+		t.Fatal("lock with an unknown fence released")
+	}
+}
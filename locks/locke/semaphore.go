@@ -0,0 +1,118 @@
+package locke
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"time"
+)
+
+// Semaphore da como mucho permits holders a la vez sobre table/lockValue.
+// Cada permiso es un lock "dynamo" exclusivo sobre el item
+// table/lockValue#permit-i de LockTable, con su propio lease, fence y
+// vencimiento; se renueva y libera con los metodos de Lock. Conviene usarlo
+// con WithPerLockFence, porque Acquire puede probar todos los permisos.
+type Semaphore struct {
+	svc       Client
+	table     string
+	lockValue string
+	lockType  string
+	permits   int
+	opts      []Option
+}
+
+// NewSemaphore crea un semaforo de permits permisos. opts se aplican a cada
+// permiso; el modo compartido no tiene sentido para un permiso.
+func NewSemaphore(svc Client, table, lockValue, lockType string, permits int, opts ...Option) (*Semaphore, error) {
+	if permits <= 0 {
+		return nil, errors.New("error: semaphore needs at least one permit")
+	}
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.mode == Shared {
+		return nil, errors.New("error: semaphore permits can not be shared")
+	}
+	return &Semaphore{
+		svc:       svc,
+		table:     table,
+		lockValue: lockValue,
+		lockType:  lockType,
+		permits:   permits,
+		opts:      opts,
+	}, nil
+}
+
+// Acquire intenta obtener un permiso con un lease de duration, probando los
+// permisos en orden aleatorio. Si estan todos tomados devuelve un *HeldError
+// con el ReleaseTime del permiso que vence antes.
+func (s *Semaphore) Acquire(ctx context.Context, duration time.Duration) (Lock, error) {
+	var first *HeldError
+	for _, i := range rand.Perm(s.permits) {
+		l, err := NewLock("dynamo", s.svc, s.table, s.permitValue(i), s.lockType, duration, s.opts...)
+		if err != nil {
+			return nil, err
+		}
+		err = l.AcquireContext(ctx)
+		if err == nil {
+			return l, nil
+		}
+		var held *HeldError
+		if !errors.As(err, &held) {
+			return nil, err
+		}
+		if first == nil || held.ReleaseTime.Before(first.ReleaseTime) {
+			first = held
+		}
+	}
+	return nil, &HeldError{
+		Table:       s.table,
+		LockValue:   s.lockValue,
+		ReleaseTime: first.ReleaseTime,
+		Err:         fmt.Errorf("all %d permits held", s.permits),
+	}
+}
+
+// AcquireWait reintenta Acquire hasta obtener un permiso o hasta que se
+// cancele ctx, con las esperas de b.
+func (s *Semaphore) AcquireWait(ctx context.Context, duration time.Duration, b Backoff) (Lock, error) {
+	var l Lock
+	err := retryHeld(ctx, optionsOf(s.opts).timeSource(), b, func(ctx context.Context) error {
+		var err error
+		l, err = s.Acquire(ctx, duration)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Holders devuelve los permisos tomados y con lease vigente.
+func (s *Semaphore) Holders(ctx context.Context) ([]LockInfo, error) {
+	var holders []LockInfo
+	start := ""
+	now := optionsOf(s.opts).timeSource().Now()
+	for {
+		page, next, err := listLocks(ctx, s.svc, tableOption(s.opts), s.table, s.permitValue(-1), 0, start, now)
+		if err != nil {
+			return nil, err
+		}
+		holders = append(holders, page...)
+		if next == "" {
+			return holders, nil
+		}
+		start = next
+	}
+}
+
+// permitValue es el lockvalue del permiso i; con i < 0 el prefijo comun.
+func (s *Semaphore) permitValue(i int) string {
+	if i < 0 {
+		return s.lockValue + "#permit-"
+	}
+	return s.lockValue + "#permit-" + strconv.Itoa(i)
+}
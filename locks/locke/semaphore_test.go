@@ -0,0 +1,140 @@
+package locke
+
+import (
+	"context"
+	"dynamodb/locks/locke/locketest"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSemaphore(t *testing.T) {
+	modes := map[string][]Option{
+		"global-fence":   nil,
+		"per-lock-fence": {WithPerLockFence()},
+	}
+	tests := map[string]func(t *testing.T, svc Client, opts []Option){
+		"Permits":     testSemaphorePermits,
+		"Expiry":      testSemaphoreExpiry,
+		"AcquireWait": testSemaphoreAcquireWait,
+	}
+	for name, opts := range modes {
+		opts := opts
+		t.Run(name, func(t *testing.T) {
+			for name, test := range tests {
+				test := test
+				t.Run(name, func(t *testing.T) {
+					t.Parallel()
+					test(t, newFakeDynamo(t), opts)
+				})
+			}
+		})
+	}
+}
+
+func newSemaphore(t *testing.T, svc Client, permits int, opts []Option) *Semaphore {
+	t.Helper()
+	s, err := NewSemaphore(svc, "Usuarios", "Pepe", "Sem1", permits, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func testSemaphorePermits(t *testing.T, svc Client, opts []Option) {
+	ctx := context.Background()
+	if _, err := NewSemaphore(svc, "Usuarios", "Pepe", "Sem1", 0, opts...); err == nil {
+		t.Fatal("semaphore without permits created")
+	}
+	if _, err := NewSemaphore(svc, "Usuarios", "Pepe", "Sem1", 2, append(opts, WithMode(Shared))...); err == nil {
+		t.Fatal("semaphore with shared permits created")
+	}
+	s := newSemaphore(t, svc, 2, opts)
+	p1, err := s.Acquire(ctx, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p2, err := s.Acquire(ctx, 2*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p1.Fence() == p2.Fence() || fenceNumber(t, p1) <= 0 || fenceNumber(t, p2) <= 0 {
+		t.Fatalf("permit fences %s and %s", p1.Fence(), p2.Fence())
+	}
+	// Todos tomados: el HeldError dice cuando vence el primero
+	_, err = s.Acquire(ctx, time.Minute)
+	var held *HeldError
+	if !errors.As(err, &held) {
+		t.Fatalf("third permit: got %v, want *HeldError", err)
+	}
+	if d := time.Until(held.ReleaseTime); d <= 50*time.Second || d > time.Minute {
+		t.Fatalf("HeldError release time in %v, want the first permit's", d)
+	}
+	holders, err := s.Holders(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(holders) != 2 {
+		t.Fatalf("%d holders, want 2", len(holders))
+	}
+	if err := p1.NewDuration(2 * time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := p1.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if holders, err = s.Holders(ctx); err != nil || len(holders) != 1 {
+		t.Fatalf("holders after Release: %v %v", holders, err)
+	}
+	p3, err := s.Acquire(ctx, time.Minute)
+	if err != nil {
+		t.Fatalf("permit after Release: %v", err)
+	}
+	if fenceNumber(t, p3) <= fenceNumber(t, p1) && fenceNumber(t, p3) <= fenceNumber(t, p2) {
+		t.Fatalf("permit fence %s not new", p3.Fence())
+	}
+}
+
+func testSemaphoreExpiry(t *testing.T, svc Client, opts []Option) {
+	ctx := context.Background()
+	clock := locketest.NewFakeClock(time.Now())
+	s := newSemaphore(t, svc, 1, append(opts, WithClock(clock)))
+	p1, err := s.Acquire(ctx, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Acquire(ctx, time.Minute); !errors.Is(err, ErrHeld) {
+		t.Fatalf("second permit: got %v, want ErrHeld", err)
+	}
+	// El permiso vencido sin Release queda libre
+	clock.Advance(time.Minute + time.Millisecond)
+	if holders, err := s.Holders(ctx); err != nil || len(holders) != 0 {
+		t.Fatalf("Holders after expiry: got %+v %v, want none", holders, err)
+	}
+	p2, err := s.Acquire(ctx, time.Minute)
+	if err != nil {
+		t.Fatalf("permit after expiry: %v", err)
+	}
+	if err := p1.Release(); !errors.Is(err, ErrExpired) {
+		t.Fatalf("Release of expired permit: got %v, want ErrExpired", err)
+	}
+	if err := p2.Release(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testSemaphoreAcquireWait(t *testing.T, svc Client, opts []Option) {
+	s := newSemaphore(t, svc, 1, opts)
+	if _, err := s.Acquire(context.Background(), 300*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	p, err := s.AcquireWait(ctx, time.Minute, FixedBackoff{Delay: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("AcquireWait after the permit expired: %v", err)
+	}
+	if err := p.Release(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,240 @@
+package locke
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Modo compartido (lectores) de dynamolock. Sobre el mismo item que el modo
+// exclusivo:
+//   - readers: SS con el id de cada lector (owner#handle).
+//   - readerrelease: releasetime del lector que vence mas tarde. Mientras
+//     readers no este vacio y readerrelease no haya pasado, no entra un
+//     escritor. Los lectores que vencen sin Release se quedan en readers
+//     hasta que el siguiente escritor los borra.
+//   - writerwaiting: hasta cuando espera un escritor con preferencia; mientras
+//     tanto no entran lectores nuevos.
+// Un lector deja fence a 0, asi que un escritor vencido ya no puede renovar
+// ni liberar. releasetime acompana a readerrelease para que el TTL no borre
+// el item con lectores vivos.
+
+func (d *dynamolock) acquireShared(ctx context.Context, l *lock, now int64) (string, error) {
+	names := map[string]string{
+		"#tabla":         "tabla",
+		"#fence":         "fence",
+		"#readers":       "readers",
+		"#readerrelease": "readerrelease",
+		"#writerwaiting": "writerwaiting",
+	}
+	values := map[string]types.AttributeValue{
+		":zero": &types.AttributeValueMemberN{Value: "0"},
+		":rt":   &types.AttributeValueMemberN{Value: strconv.FormatInt(ceilSeconds(l.releaseTime), 10)},
+		":me":   &types.AttributeValueMemberSS{Value: []string{readerID(l)}},
+	}
+	leaseTerms(names, values, now)
+	var fence, fenceSet string
+	if d.perLockFence {
+		fenceSet = ", " + counterFence(names, values, l.clock.Now().UnixMicro())
+	} else {
+		f, err := d.nextFence(ctx, 1)
+		if err != nil {
+			return "", err
+		}
+		fence = strconv.FormatInt(f, 10)
+	}
+	// Sin escritor vivo ni escritor esperando
+	cond := "(attribute_not_exists(#tabla) OR #fence = :zero OR " + leaseExpired + ") AND " +
+		"(attribute_not_exists(#writerwaiting) OR #writerwaiting < :now)"
+	// Primer intento como el lector que vence mas tarde; si ya hay uno que
+	// vence despues, segundo intento sin tocar readerrelease ni releasetime
+	uio, err := d.updateLock(ctx, l, names, values,
+		cond+" AND (attribute_not_exists(#readerrelease) OR #readerrelease <= :rt)",
+		"SET #fence = :zero, #releasetime = :rt, #readerrelease = :rt"+fenceSet+" ADD #readers :me")
+	var ccfe *types.ConditionalCheckFailedException
+	if errors.As(err, &ccfe) && !writerLive(ccfe.Item, now) && !writerWaiting(ccfe.Item, now) {
+		delete(values, ":rt")
+		delete(names, "#readerrelease")
+		uio, err = d.updateLock(ctx, l, names, values, cond,
+			"SET #fence = :zero"+fenceSet+" ADD #readers :me")
+	}
+	if err == nil {
+		if d.perLockFence {
+			fence = itemNumber(uio.Attributes, "fencecounter")
+		}
+		return fence, nil
+	}
+	if errors.As(err, &ccfe) {
+		return "", heldError(l, ccfe.Item, ccfe, true)
+	}
+	return "", serviceError(err)
+}
+
+func (d *dynamolock) renewShared(ctx context.Context, l *lock, nrt, now int64) error {
+	_, err := d.updateLock(ctx, l,
+		map[string]string{
+			"#readers":       "readers",
+			"#readerrelease": "readerrelease",
+			"#releasetime":   "releasetime",
+		},
+		map[string]types.AttributeValue{
+			":meid": &types.AttributeValueMemberS{Value: readerID(l)},
+			":nrt":  &types.AttributeValueMemberN{Value: strconv.FormatInt(ceilSeconds(nrt), 10)},
+		},
+		"contains(#readers, :meid) AND (attribute_not_exists(#readerrelease) OR #readerrelease <= :nrt)",
+		"SET #readerrelease = :nrt, #releasetime = :nrt",
+	)
+	var ccfe *types.ConditionalCheckFailedException
+	if errors.As(err, &ccfe) {
+		// Otro lector ya vence despues que nosotros: no hay nada que ampliar
+		if containsString(itemStringSet(ccfe.Item, "readers"), readerID(l)) {
+			return nil
+		}
+		return ErrNotOwner
+	}
+	return serviceError(err)
+}
+
+func (d *dynamolock) releaseShared(ctx context.Context, l *lock, now int64) error {
+	_, err := d.updateLock(ctx, l,
+		map[string]string{
+			"#readers": "readers",
+		},
+		map[string]types.AttributeValue{
+			":meid": &types.AttributeValueMemberS{Value: readerID(l)},
+			":me":   &types.AttributeValueMemberSS{Value: []string{readerID(l)}},
+		},
+		"contains(#readers, :meid)",
+		"DELETE #readers :me",
+	)
+	var ccfe *types.ConditionalCheckFailedException
+	if errors.As(err, &ccfe) {
+		return ErrNotOwner
+	}
+	return serviceError(err)
+}
+
+// markWriterWaiting anuncia que un escritor espera a que salgan los lectores,
+// para que no entren lectores nuevos durante writerWait. Es best effort: si
+// falla, el escritor sigue esperando sin preferencia.
+func (d *dynamolock) markWriterWaiting(ctx context.Context, l *lock, now int64) {
+	until := time.UnixMilli(now).Add(d.writerWait).Unix()
+	d.updateLock(ctx, l,
+		map[string]string{
+			"#readers":       "readers",
+			"#writerwaiting": "writerwaiting",
+		},
+		map[string]types.AttributeValue{
+			":until": &types.AttributeValueMemberN{Value: strconv.FormatInt(until, 10)},
+		},
+		"attribute_exists(#readers)",
+		"SET #writerwaiting = :until",
+	)
+}
+
+// holderCheck es la condicion de que l sigue teniendo el lock, para usarla
+// dentro de una transaccion.
+func (d *dynamolock) holderCheck(l *lock, now int64) *types.ConditionCheck {
+	check := &types.ConditionCheck{
+		TableName: aws.String(d.lockTable),
+		Key: map[string]types.AttributeValue{
+			"tabla":     &types.AttributeValueMemberS{Value: l.table},
+			"lockvalue": &types.AttributeValueMemberS{Value: l.lockValue},
+		},
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	}
+	if d.shared {
+		// Los lectores vencen cuando now, en segundos, pasa de readerrelease
+		check.ConditionExpression = aws.String("contains(#readers, :meid) AND #readerrelease >= :now")
+		check.ExpressionAttributeNames = map[string]string{
+			"#readers":       "readers",
+			"#readerrelease": "readerrelease",
+		}
+		check.ExpressionAttributeValues = map[string]types.AttributeValue{
+			":meid": &types.AttributeValueMemberS{Value: readerID(l)},
+			":now":  &types.AttributeValueMemberN{Value: strconv.FormatInt(now/1000, 10)},
+		}
+		return check
+	}
+	if d.versioned {
+		check.ConditionExpression = aws.String("#fence = :fence AND #owner = :owner AND #rvn = :myrvn")
+		check.ExpressionAttributeNames = map[string]string{"#fence": "fence", "#owner": "owner", "#rvn": "rvn"}
+		check.ExpressionAttributeValues = map[string]types.AttributeValue{
+			":fence": &types.AttributeValueMemberN{Value: l.fence},
+			":owner": &types.AttributeValueMemberS{Value: l.owner},
+			":myrvn": &types.AttributeValueMemberS{Value: d.rvn},
+		}
+		return check
+	}
+	check.ConditionExpression = aws.String(
+		"#fence = :fence AND " +
+			"#owner = :owner AND " +
+			leaseLive,
+	)
+	check.ExpressionAttributeNames = map[string]string{
+		"#fence": "fence",
+		"#owner": "owner",
+	}
+	check.ExpressionAttributeValues = map[string]types.AttributeValue{
+		":fence": &types.AttributeValueMemberN{Value: l.fence},
+		":owner": &types.AttributeValueMemberS{Value: l.owner},
+	}
+	leaseTerms(check.ExpressionAttributeNames, check.ExpressionAttributeValues, now)
+	return check
+}
+
+// updateLock hace un UpdateItem condicional sobre el item de l.
+func (d *dynamolock) updateLock(ctx context.Context, l *lock, names map[string]string, values map[string]types.AttributeValue, cond, update string) (*dynamodb.UpdateItemOutput, error) {
+	return d.svc.UpdateItem(ctx,
+		&dynamodb.UpdateItemInput{
+			TableName: aws.String(d.lockTable),
+			Key: map[string]types.AttributeValue{
+				"tabla":     &types.AttributeValueMemberS{Value: l.table},
+				"lockvalue": &types.AttributeValueMemberS{Value: l.lockValue},
+			},
+			ConditionExpression:                 aws.String(cond),
+			ExpressionAttributeNames:            names,
+			ExpressionAttributeValues:           values,
+			UpdateExpression:                    aws.String(update),
+			ReturnValues:                        types.ReturnValueUpdatedNew,
+			ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+		},
+	)
+}
+
+// readerID identifica al lector l dentro de readers.
+func readerID(l *lock) string {
+	return l.owner + "#" + l.handleID
+}
+
+// writerLive indica si el item tiene un escritor con lease vigente.
+func writerLive(item map[string]types.AttributeValue, now int64) bool {
+	fence := itemNumber(item, "fence")
+	return fence != "" && fence != "0" && itemLease(item).UnixMilli() >= now
+}
+
+// writerWaiting indica si hay un escritor con preferencia esperando.
+func writerWaiting(item map[string]types.AttributeValue, now int64) bool {
+	return !itemTime(item, "writerwaiting").IsZero() && itemTime(item, "writerwaiting").Unix() >= now/1000
+}
+
+// blockedByReaders indica si un escritor no pudo entrar solo por los lectores.
+func blockedByReaders(item map[string]types.AttributeValue, now int64) bool {
+	return len(itemStringSet(item, "readers")) > 0 &&
+		itemTime(item, "readerrelease").Unix() >= now/1000 &&
+		!writerLive(item, now)
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,99 @@
+package locke
+
+import (
+	"context"
+	"dynamodb/locks/locke/locketest"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSharedDynamo(t *testing.T) {
+	svc := newFakeDynamo(t)
+	r1 := dynamoLock(t, svc, "Pepe", "Lock1", time.Minute, WithMode(Shared))
+	r2 := dynamoLock(t, svc, "Pepe", "Lock1", time.Minute, WithMode(Shared))
+	w := dynamoLock(t, svc, "Pepe", "Lock1", time.Minute, WithMode(Exclusive))
+	if err := r1.Acquire(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r2.Acquire(); err != nil {
+		t.Fatal(err)
+	}
+	var held *HeldError
+	if err := w.Acquire(); !errors.As(err, &held) {
+		t.Fatalf("writer with readers: got %v, want *HeldError", err)
+	}
+	if held.Readers != 2 {
+		t.Fatalf("HeldError with %d readers, want 2", held.Readers)
+	}
+	if err := r1.NewDuration(2 * time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := r1.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Acquire(); !errors.As(err, &held) {
+		t.Fatalf("writer with one reader: got %v, want *HeldError", err)
+	}
+	if err := r2.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Acquire(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r1.Acquire(); !errors.As(err, &held) {
+		t.Fatalf("reader with writer: got %v, want *HeldError", err)
+	}
+}
+
+func TestWriterPreference(t *testing.T) {
+	svc := newFakeDynamo(t)
+	r1 := dynamoLock(t, svc, "Pepe", "Lock1", time.Minute, WithMode(Shared))
+	r2 := dynamoLock(t, svc, "Pepe", "Lock1", time.Minute, WithMode(Shared))
+	if err := r1.Acquire(); err != nil {
+		t.Fatal(err)
+	}
+	// Sin preferencia los lectores siguen entrando
+	if err := dynamoLock(t, svc, "Pepe", "Lock1", time.Minute).Acquire(); !errors.Is(err, ErrHeld) {
+		t.Fatalf("writer with a reader: got %v, want ErrHeld", err)
+	}
+	if err := r2.Acquire(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r2.Release(); err != nil {
+		t.Fatal(err)
+	}
+	// Con preferencia el escritor que espera cierra el paso a lectores nuevos
+	clock := locketest.NewFakeClock(time.Now())
+	w := dynamoLock(t, svc, "Pepe", "Lock1", time.Minute, WithWriterPreference(time.Minute), WithClock(clock))
+	if err := w.Acquire(); !errors.Is(err, ErrHeld) {
+		t.Fatalf("preferred writer with a reader: got %v, want ErrHeld", err)
+	}
+	if err := r2.Acquire(); !errors.Is(err, ErrHeld) {
+		t.Fatalf("new reader with a waiting writer: got %v, want ErrHeld", err)
+	}
+	// y lo obtiene en cuanto salen los lectores que habia
+	done := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		done <- w.AcquireWait(ctx, FixedBackoff{Delay: 10 * time.Millisecond})
+	}()
+	clock.BlockUntil(1)
+	if err := r1.Release(); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(10 * time.Millisecond)
+	if err := <-done; err != nil {
+		t.Fatalf("preferred writer after the readers left: %v", err)
+	}
+	if err := r2.Acquire(); !errors.Is(err, ErrHeld) {
+		t.Fatalf("reader with writer: got %v, want ErrHeld", err)
+	}
+	if err := w.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r2.Acquire(); err != nil {
+		t.Fatalf("reader after the writer left: %v", err)
+	}
+}
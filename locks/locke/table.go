@@ -0,0 +1,211 @@
+package locke
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// TableClient son las operaciones de DynamoDB que usan EnsureTable y
+// ValidateTable.
+type TableClient interface {
+	CreateTable(ctx context.Context, params *dynamodb.CreateTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.CreateTableOutput, error)
+	DescribeTable(ctx context.Context, params *dynamodb.DescribeTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error)
+	UpdateTimeToLive(ctx context.Context, params *dynamodb.UpdateTimeToLiveInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateTimeToLiveOutput, error)
+	DescribeTimeToLive(ctx context.Context, params *dynamodb.DescribeTimeToLiveInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DescribeTimeToLiveOutput, error)
+	UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+}
+
+// tableWait es lo que espera EnsureTable a que una tabla recien creada este
+// activa.
+const tableWait = 5 * time.Minute
+
+// EnsureTable deja la tabla de locks name lista: la crea si no existe (clave
+// tabla/lockvalue, pago por peticion), activa el TTL sobre releasetime y crea
+// el item del fencing global name/name. Se puede llamar cada vez que arranca
+// la aplicacion: no toca lo que ya esta bien, y no reinicia el fencing. Si la
+// tabla existe pero no sirve devuelve un *TableError.
+func EnsureTable(ctx context.Context, svc TableClient, name string) error {
+	_, err := svc.CreateTable(ctx, &dynamodb.CreateTableInput{
+		TableName:   aws.String(name),
+		BillingMode: types.BillingModePayPerRequest,
+		KeySchema: []types.KeySchemaElement{
+			{
+				AttributeName: aws.String("tabla"),
+				KeyType:       types.KeyTypeHash,
+			},
+			{
+				AttributeName: aws.String("lockvalue"),
+				KeyType:       types.KeyTypeRange,
+			},
+		},
+		AttributeDefinitions: []types.AttributeDefinition{
+			{
+				AttributeName: aws.String("tabla"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+			{
+				AttributeName: aws.String("lockvalue"),
+				AttributeType: types.ScalarAttributeTypeS,
+			},
+		},
+	})
+	var riue *types.ResourceInUseException
+	if err != nil && !errors.As(err, &riue) {
+		return serviceError(err)
+	}
+	// Recien creada, o creandose desde otro proceso
+	err = dynamodb.NewTableExistsWaiter(svc).Wait(ctx,
+		&dynamodb.DescribeTableInput{
+			TableName: aws.String(name),
+		}, tableWait)
+	if err != nil {
+		return fmt.Errorf("error: waiting for table %s to become active: %w", name, err)
+	}
+	// Con otra clave no se toca nada: ni el TTL ni el fence
+	dto, err := svc.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(name)})
+	if err != nil {
+		return serviceError(err)
+	}
+	if len(keyProblems(dto.Table)) > 0 {
+		return ValidateTable(ctx, svc, name)
+	}
+	ttl, err := svc.DescribeTimeToLive(ctx, &dynamodb.DescribeTimeToLiveInput{TableName: aws.String(name)})
+	if err != nil {
+		return serviceError(err)
+	}
+	if ttlOff(ttl.TimeToLiveDescription) {
+		_, err = svc.UpdateTimeToLive(ctx, &dynamodb.UpdateTimeToLiveInput{
+			TableName: aws.String(name),
+			TimeToLiveSpecification: &types.TimeToLiveSpecification{
+				AttributeName: aws.String("releasetime"),
+				Enabled:       aws.Bool(true),
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("error: enabling TTL on table %s: %w", name, serviceError(err))
+		}
+	}
+	// El fencing global empieza en 0 solo si no existe
+	_, err = svc.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(name),
+		Key:       fenceKey(name),
+		ExpressionAttributeNames: map[string]string{
+			"#fence": "fence",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":zero": &types.AttributeValueMemberN{Value: "0"},
+		},
+		UpdateExpression: aws.String("SET #fence = if_not_exists(#fence, :zero)"),
+	})
+	if err != nil {
+		return serviceError(err)
+	}
+	return ValidateTable(ctx, svc, name)
+}
+
+// ValidateTable comprueba que la tabla de locks name sirve para locke: que
+// existe y esta activa, su clave, el TTL sobre releasetime y el item del
+// fencing global. Si no existe devuelve ErrTableNotFound; si esta mal
+// configurada, un *TableError con todos los problemas.
+func ValidateTable(ctx context.Context, svc TableClient, name string) error {
+	dto, err := svc.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(name)})
+	if err != nil {
+		return serviceError(err)
+	}
+	var problems []string
+	if st := dto.Table.TableStatus; st != types.TableStatusActive && st != types.TableStatusUpdating {
+		problems = append(problems, fmt.Sprintf("status is %s", st))
+	}
+	problems = append(problems, keyProblems(dto.Table)...)
+	ttl, err := svc.DescribeTimeToLive(ctx, &dynamodb.DescribeTimeToLiveInput{TableName: aws.String(name)})
+	if err != nil {
+		return serviceError(err)
+	}
+	if d := ttl.TimeToLiveDescription; ttlOff(d) {
+		problems = append(problems, "TTL is not enabled")
+	} else if attr := aws.ToString(d.AttributeName); attr != "releasetime" {
+		problems = append(problems, fmt.Sprintf("TTL is on %q instead of \"releasetime\"", attr))
+	}
+	// Con otra clave DynamoDB rechaza el GetItem del fence
+	if len(keyProblems(dto.Table)) == 0 {
+		gio, err := svc.GetItem(ctx, &dynamodb.GetItemInput{
+			TableName:      aws.String(name),
+			Key:            fenceKey(name),
+			ConsistentRead: aws.Bool(true),
+		})
+		if err != nil {
+			return serviceError(err)
+		}
+		if itemNumber(gio.Item, "fence") == "" {
+			problems = append(problems, "global fence item is missing")
+		}
+	}
+	if len(problems) > 0 {
+		return &TableError{Table: name, Problems: problems}
+	}
+	return nil
+}
+
+// keyProblems compara la clave de la tabla con tabla (HASH, S) y lockvalue
+// (RANGE, S).
+func keyProblems(t *types.TableDescription) []string {
+	want := map[string]types.KeyType{"tabla": types.KeyTypeHash, "lockvalue": types.KeyTypeRange}
+	attrTypes := map[string]types.ScalarAttributeType{}
+	for _, ad := range t.AttributeDefinitions {
+		attrTypes[aws.ToString(ad.AttributeName)] = ad.AttributeType
+	}
+	var problems []string
+	if len(t.KeySchema) != len(want) {
+		problems = append(problems, fmt.Sprintf("key has %d attributes, want tabla and lockvalue", len(t.KeySchema)))
+	}
+	for _, k := range t.KeySchema {
+		attr := aws.ToString(k.AttributeName)
+		kt, ok := want[attr]
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("unexpected key attribute %q", attr))
+		case kt != k.KeyType:
+			problems = append(problems, fmt.Sprintf("key attribute %q is %s, want %s", attr, k.KeyType, kt))
+		case attrTypes[attr] != types.ScalarAttributeTypeS:
+			problems = append(problems, fmt.Sprintf("key attribute %q has type %s, want S", attr, attrTypes[attr]))
+		}
+	}
+	return problems
+}
+
+// ttlOff indica si el TTL no esta activo ni activandose.
+func ttlOff(d *types.TimeToLiveDescription) bool {
+	return d == nil || (d.TimeToLiveStatus != types.TimeToLiveStatusEnabled &&
+		d.TimeToLiveStatus != types.TimeToLiveStatusEnabling)
+}
+
+// fenceKey es la clave del item del fencing global de la tabla name.
+func fenceKey(name string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"tabla":     &types.AttributeValueMemberS{Value: name},
+		"lockvalue": &types.AttributeValueMemberS{Value: name},
+	}
+}
+
+// TableError es el error de ValidateTable/EnsureTable cuando la tabla de
+// locks existe pero no sirve. errors.Is(err, ErrTableMisconfigured) es cierto.
+type TableError struct {
+	Table    string
+	Problems []string
+}
+
+func (e *TableError) Error() string {
+	return fmt.Sprintf("error: lock table %s misconfigured: %s", e.Table, strings.Join(e.Problems, "; "))
+}
+
+func (e *TableError) Is(target error) bool {
+	return target == ErrTableMisconfigured
+}
@@ -0,0 +1,65 @@
+package locke
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestEnsureTable(t *testing.T) {
+	ctx := context.Background()
+	svc := newFakeDynamo(t)
+	l := acquired(t, dynamoLock(t, svc, "Pepe", "Lock1", time.Minute))
+	first := fenceNumber(t, l)
+	// Otra vez, como al arrancar la aplicacion: no reinicia el fencing
+	if err := EnsureTable(ctx, svc, DefaultTable); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Acquire(); err != nil {
+		t.Fatal(err)
+	}
+	if fenceNumber(t, l) <= first {
+		t.Fatalf("fence %s after EnsureTable, want > %d", l.Fence(), first)
+	}
+
+	if err := ValidateTable(ctx, svc, "Otra"); !errors.Is(err, ErrTableNotFound) {
+		t.Fatalf("missing table: got %v, want ErrTableNotFound", err)
+	}
+	_, err := svc.CreateTable(ctx, &dynamodb.CreateTableInput{
+		TableName:   aws.String("Otra"),
+		BillingMode: types.BillingModePayPerRequest,
+		KeySchema: []types.KeySchemaElement{
+			{AttributeName: aws.String("tabla"), KeyType: types.KeyTypeHash},
+		},
+		AttributeDefinitions: []types.AttributeDefinition{
+			{AttributeName: aws.String("tabla"), AttributeType: types.ScalarAttributeTypeS},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = EnsureTable(ctx, svc, "Otra")
+	var te *TableError
+	if !errors.As(err, &te) || !errors.Is(err, ErrTableMisconfigured) {
+		t.Fatalf("table without lockvalue: got %v, want *TableError", err)
+	}
+	if len(te.Problems) != 2 {
+		t.Fatalf("table without lockvalue: got problems %q, want key and TTL", te.Problems)
+	}
+	// Una tabla que no sirve se queda como estaba
+	ttl, err := svc.DescribeTimeToLive(ctx, &dynamodb.DescribeTimeToLiveInput{TableName: aws.String("Otra")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ttlOff(ttl.TimeToLiveDescription) {
+		t.Fatal("EnsureTable enabled TTL on a misconfigured table")
+	}
+}
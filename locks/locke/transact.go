@@ -0,0 +1,79 @@
+package locke
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// MaxTransactItems es el limite de acciones de TransactWriteItems.
+const MaxTransactItems = 100
+
+// TransactWriteItemsAPI es el cliente minimo para TransactWriteWithLock.
+// *dynamodb.Client lo implementa.
+type TransactWriteItemsAPI interface {
+	TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error)
+}
+
+// TransactWriteWithLock hace in.TransactItems en una transaccion que ademas
+// comprueba en LockTable que l sigue siendo el holder (mismo fence y owner, o
+// sigue en readers si es un lector) y que su lease no vencio. Asi las
+// escrituras solo se confirman si el lock sigue tomado en el momento del
+// commit, sin fiarse del reloj local. Si el lock ya no es nuestro devuelve
+// ErrNotOwner o ErrExpired y el handle queda como no adquirido. l debe ser un
+// lock "dynamo" y caben como mucho MaxTransactItems-1 acciones del caller.
+func TransactWriteWithLock(ctx context.Context, svc TransactWriteItemsAPI, l Lock, in *dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+	lo, ok := asLock(l)
+	if !ok {
+		return nil, errors.New("error: transactional writes need a dynamo lock")
+	}
+	d, ok := lo.b.(*dynamolock)
+	if !ok {
+		return nil, errors.New("error: transactional writes need a dynamo lock")
+	}
+	if len(in.TransactItems) >= MaxTransactItems {
+		return nil, errors.New("error: too many items for a locked transaction")
+	}
+	// La condicion y el lost de despues son del mismo fence
+	lo.mu.Lock()
+	fence := lo.fence
+	if fence == "0" {
+		lo.mu.Unlock()
+		return nil, ErrNotAcquired
+	}
+	check := d.holderCheck(lo, lo.clock.Now().UTC().UnixMilli())
+	lo.mu.Unlock()
+	params := *in
+	params.TransactItems = append(append([]types.TransactWriteItem{}, in.TransactItems...),
+		types.TransactWriteItem{ConditionCheck: check})
+	out, err := svc.TransactWriteItems(ctx, &params)
+	var tce *types.TransactionCanceledException
+	if err != nil && errors.As(err, &tce) && len(tce.CancellationReasons) == len(params.TransactItems) {
+		reason := tce.CancellationReasons[len(tce.CancellationReasons)-1]
+		if aws.ToString(reason.Code) == "ConditionalCheckFailed" {
+			lo.mu.Lock()
+			defer lo.mu.Unlock()
+			if d.shared {
+				// Sigue entre los lectores pero vencio su lease
+				if containsString(itemStringSet(reason.Item, "readers"), readerID(lo)) {
+					err = fmt.Errorf("%w: %w", ErrExpired, err)
+				} else {
+					err = ErrNotOwner
+				}
+			} else {
+				err = recordError(lo, reason.Item, err)
+			}
+			// Si el handle se libero o se volvio a adquirir mientras, su
+			// fence actual no es el que fallo
+			if lo.fence != fence {
+				return out, err
+			}
+			return out, lo.lost(err)
+		}
+	}
+	return out, serviceError(err)
+}
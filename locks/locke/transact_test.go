@@ -0,0 +1,149 @@
+package locke
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// addOne suma uno al atributo n del item x de Datos dentro de una transaccion
+// protegida por l.
+func addOne(svc *dynamodb.Client, l Lock) error {
+	_, err := TransactWriteWithLock(context.Background(), svc, l, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{{
+			Update: &types.Update{
+				TableName:                 aws.String("Datos"),
+				Key:                       map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: "x"}},
+				UpdateExpression:          aws.String("ADD #n :uno"),
+				ExpressionAttributeNames:  map[string]string{"#n": "n"},
+				ExpressionAttributeValues: map[string]types.AttributeValue{":uno": &types.AttributeValueMemberN{Value: "1"}},
+			},
+		}},
+	})
+	return err
+}
+
+// dataN es el atributo n del item x de Datos.
+func dataN(t *testing.T, svc *dynamodb.Client) string {
+	t.Helper()
+	gio, err := svc.GetItem(context.Background(), &dynamodb.GetItemInput{
+		TableName: aws.String("Datos"),
+		Key:       map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: "x"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return itemNumber(gio.Item, "n")
+}
+
+func TestTransactWriteWithLock(t *testing.T) {
+	ctx := context.Background()
+	svc := newFakeDynamo(t)
+	newDataTable(t, svc)
+	l := dynamoLock(t, svc, "Pepe", "Lock1", time.Minute)
+	if err := addOne(svc, l); !errors.Is(err, ErrNotAcquired) {
+		t.Fatalf("write without the lock: got %v, want ErrNotAcquired", err)
+	}
+	if err := l.Acquire(); err != nil {
+		t.Fatal(err)
+	}
+	if err := addOne(svc, l); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ForceRelease(ctx, svc, "Usuarios", "Pepe"); err != nil {
+		t.Fatal(err)
+	}
+	if err := addOne(svc, l); !errors.Is(err, ErrNotOwner) {
+		t.Fatalf("write after ForceRelease: got %v, want ErrNotOwner", err)
+	}
+	if l.Fence() != "0" {
+		t.Fatalf("lost lock keeps fence %s", l.Fence())
+	}
+	// La escritura cancelada no se aplico
+	if n := dataN(t, svc); n != "1" {
+		t.Fatalf("data item n = %q, want 1", n)
+	}
+}
+
+// reacquireClient libera y vuelve a adquirir l antes de cada transaccion, como
+// otra goroutine con el mismo handle.
+type reacquireClient struct {
+	*dynamodb.Client
+	l Lock
+}
+
+func (c reacquireClient) TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+	if err := c.l.Release(); err != nil {
+		return nil, err
+	}
+	if err := c.l.Acquire(); err != nil {
+		return nil, err
+	}
+	return c.Client.TransactWriteItems(ctx, params, optFns...)
+}
+
+func TestTransactWriteReacquired(t *testing.T) {
+	svc := newFakeDynamo(t)
+	newDataTable(t, svc)
+	l := acquired(t, dynamoLock(t, svc, "Pepe", "Lock1", time.Minute))
+	_, err := TransactWriteWithLock(context.Background(), reacquireClient{Client: svc, l: l}, l, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{{
+			Put: &types.Put{
+				TableName: aws.String("Datos"),
+				Item:      map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: "x"}},
+			},
+		}},
+	})
+	if err == nil {
+		t.Fatal("write with an old fence committed")
+	}
+	// El fallo era del fence anterior: el nuevo sigue valiendo
+	if l.Fence() == "0" {
+		t.Fatal("reacquired lock dropped by a stale condition")
+	}
+	if err := l.Release(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTransactWriteWithSharedLock(t *testing.T) {
+	ctx := context.Background()
+	svc := newFakeDynamo(t)
+	newDataTable(t, svc)
+	r1 := acquired(t, dynamoLock(t, svc, "Pepe", "Lock1", time.Minute, WithMode(Shared)))
+	r2 := acquired(t, dynamoLock(t, svc, "Pepe", "Lock1", time.Minute, WithMode(Shared)))
+	if err := addOne(svc, r1); err != nil {
+		t.Fatal(err)
+	}
+	if err := r2.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if err := addOne(svc, r2); !errors.Is(err, ErrNotAcquired) {
+		t.Fatalf("released reader: got %v, want ErrNotAcquired", err)
+	}
+	// Segun LockTable los lectores vencieron, aunque el reloj local no lo diga
+	_, err := svc.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(DefaultTable),
+		Key:                       map[string]types.AttributeValue{"tabla": &types.AttributeValueMemberS{Value: "Usuarios"}, "lockvalue": &types.AttributeValueMemberS{Value: "Pepe"}},
+		UpdateExpression:          aws.String("SET #readerrelease = :past"),
+		ExpressionAttributeNames:  map[string]string{"#readerrelease": "readerrelease"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{":past": &types.AttributeValueMemberN{Value: "1"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := addOne(svc, r1); !errors.Is(err, ErrExpired) {
+		t.Fatalf("write with expired readers: got %v, want ErrExpired", err)
+	}
+	if r1.Fence() != "0" {
+		t.Fatalf("expired reader keeps fence %s", r1.Fence())
+	}
+	if n := dataN(t, svc); n != "1" {
+		t.Fatalf("data item n = %q, want 1", n)
+	}
+}
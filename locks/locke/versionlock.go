@@ -0,0 +1,171 @@
+package locke
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Modo con numero de version (WithVersionLease) de dynamolock. Las decisiones
+// de vencimiento no comparan releasetime con el reloj de nadie:
+//   - rvn: version del registro, un valor aleatorio nuevo en cada Acquire y
+//     NewDuration.
+//   - leaseduration: el lease en milisegundos que pidio el holder con esa
+//     version.
+// Un handle que encuentra el lock tomado apunta la version y cuando la vio
+// con su reloj monotono. Solo si en un intento posterior la version sigue
+// igual despues de leaseduration lo toma, con la condicion de que rvn no haya
+// cambiado, y con el lease que pidio el, no el del holder. El holder renueva y
+// libera con la condicion de que rvn sea la suya. releasetime se sigue
+// escribiendo para el TTL. No se mezcla con el
+// modo compartido ni con locks sin WithVersionLease sobre el mismo item.
+
+// versionSeen es la version del holder que observo un handle que espera.
+type versionSeen struct {
+	rvn   string
+	at    time.Time
+	lease time.Duration
+}
+
+func (d *dynamolock) acquireVersioned(ctx context.Context, l *lock, now int64) (string, error) {
+	gio, err := d.svc.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(d.lockTable),
+		Key:            lockKey(l),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return "", serviceError(err)
+	}
+	item := gio.Item
+	cond := "attribute_not_exists(#tabla) OR #fence = :zero"
+	// Valores de la condicion de tomar un lock abandonado
+	seen := map[string]types.AttributeValue{}
+	if fence := itemNumber(item, "fence"); fence != "" && fence != "0" {
+		rvn := itemString(item, "rvn")
+		if d.seen.at.IsZero() || d.seen.rvn != rvn {
+			d.seen = versionSeen{rvn: rvn, at: l.clock.Now(), lease: itemLeaseDuration(item, l.duration)}
+		}
+		if wait := d.seen.lease - l.clock.Now().Sub(d.seen.at); wait > 0 {
+			held := heldError(l, item, errors.New("lease version unchanged for less than its duration"), false).(*HeldError)
+			// Cuando se podra tomar segun nuestro reloj
+			held.ReleaseTime = l.clock.Now().Add(wait).UTC()
+			return "", held
+		}
+		// La version no cambio en todo el lease: el holder no renovo
+		cond = "#rvn = :seen"
+		seen[":seen"] = &types.AttributeValueMemberS{Value: rvn}
+		if rvn == "" {
+			cond = "attribute_not_exists(#rvn) AND #fence = :seenfence"
+			seen = map[string]types.AttributeValue{":seenfence": &types.AttributeValueMemberN{Value: fence}}
+		}
+	}
+	var fence string
+	if !d.perLockFence {
+		f, err := d.nextFence(ctx, 1)
+		if err != nil {
+			return "", err
+		}
+		fence = strconv.FormatInt(f, 10)
+	}
+	rvn := newHandleID()
+	in := d.acquireInput(l, now, fence)
+	in.ConditionExpression = aws.String(cond)
+	versionUpdate(in.ExpressionAttributeNames, in.ExpressionAttributeValues, rvn, l.releaseTime-now)
+	*in.UpdateExpression = strings.Replace(*in.UpdateExpression, " REMOVE ",
+		", #rvn = :rvn, #leaseduration = :lease REMOVE ", 1)
+	// DynamoDB rechaza nombres y valores que la condicion ya no usa
+	delete(in.ExpressionAttributeValues, ":now")
+	delete(in.ExpressionAttributeValues, ":nowms")
+	if len(seen) > 0 {
+		delete(in.ExpressionAttributeNames, "#tabla")
+		delete(in.ExpressionAttributeValues, ":zero")
+		for k, v := range seen {
+			in.ExpressionAttributeValues[k] = v
+		}
+	}
+	in.ReturnValues = types.ReturnValueUpdatedNew
+	uio, err := d.svc.UpdateItem(ctx, in)
+	if err != nil {
+		var ccfe *types.ConditionalCheckFailedException
+		if errors.As(err, &ccfe) {
+			// Otro llego antes; su version se observa en el siguiente intento
+			return "", heldError(l, ccfe.Item, ccfe, false)
+		}
+		return "", serviceError(err)
+	}
+	d.rvn = rvn
+	d.seen = versionSeen{}
+	return itemNumber(uio.Attributes, "fence"), nil
+}
+
+func (d *dynamolock) renewVersioned(ctx context.Context, l *lock, nrt, now int64) error {
+	names := map[string]string{
+		"#fence":         "fence",
+		"#owner":         "owner",
+		"#releasetime":   "releasetime",
+		"#releasetimems": "releasetimems",
+	}
+	values := map[string]types.AttributeValue{
+		":fence": &types.AttributeValueMemberN{Value: l.fence},
+		":owner": &types.AttributeValueMemberS{Value: l.owner},
+		":myrvn": &types.AttributeValueMemberS{Value: d.rvn},
+		":nrt":   &types.AttributeValueMemberN{Value: strconv.FormatInt(ceilSeconds(nrt), 10)},
+		":nrtms": &types.AttributeValueMemberN{Value: strconv.FormatInt(nrt, 10)},
+	}
+	rvn := newHandleID()
+	versionUpdate(names, values, rvn, nrt-now)
+	_, err := d.updateLock(ctx, l, names, values,
+		"#fence = :fence AND #owner = :owner AND #rvn = :myrvn",
+		"SET #releasetime = :nrt, #releasetimems = :nrtms, #rvn = :rvn, #leaseduration = :lease")
+	if err == nil {
+		d.rvn = rvn
+	}
+	return ownerError(l, err)
+}
+
+// releaseVersionedInput prepara la escritura condicional que libera l si la
+// version sigue siendo la suya.
+func (d *dynamolock) releaseVersionedInput(l *lock) *dynamodb.UpdateItemInput {
+	return &dynamodb.UpdateItemInput{
+		TableName:           aws.String(d.lockTable),
+		Key:                 lockKey(l),
+		ConditionExpression: aws.String("#fence = :fence AND #owner = :owner AND #rvn = :myrvn"),
+		ExpressionAttributeNames: map[string]string{
+			"#fence": "fence",
+			"#owner": "owner",
+			"#rvn":   "rvn",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":zero":  &types.AttributeValueMemberN{Value: "0"},
+			":fence": &types.AttributeValueMemberN{Value: l.fence},
+			":owner": &types.AttributeValueMemberS{Value: l.owner},
+			":myrvn": &types.AttributeValueMemberS{Value: d.rvn},
+		},
+		UpdateExpression:                    aws.String("SET #fence = :zero"),
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	}
+}
+
+// versionUpdate anade a names/values la nueva version rvn y su lease en
+// milisegundos.
+func versionUpdate(names map[string]string, values map[string]types.AttributeValue, rvn string, leaseMs int64) {
+	names["#rvn"] = "rvn"
+	names["#leaseduration"] = "leaseduration"
+	values[":rvn"] = &types.AttributeValueMemberS{Value: rvn}
+	values[":lease"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(leaseMs, 10)}
+}
+
+// itemLeaseDuration es el lease que pidio el holder del item; si no lo
+// guardo, own, el que pidio el que espera.
+func itemLeaseDuration(item map[string]types.AttributeValue, own time.Duration) time.Duration {
+	if ms, err := strconv.ParseInt(itemNumber(item, "leaseduration"), 10, 64); err == nil {
+		return time.Duration(ms) * time.Millisecond
+	}
+	return own
+}
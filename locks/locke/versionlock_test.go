@@ -0,0 +1,89 @@
+package locke
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"dynamodb/locks/locke/locketest"
+)
+
+func TestVersionLease(t *testing.T) {
+	svc := newFakeDynamo(t)
+	start := time.Now()
+	clock := locketest.NewFakeClock(start)
+	// Con el mismo lease: el que espera con AcquireWait no vence mientras observa
+	holder := acquired(t, dynamoLock(t, svc, "Pepe", "Lock1", time.Minute, WithVersionLease(), WithClock(clock)))
+	other := dynamoLock(t, svc, "Pepe", "Lock1", time.Minute, WithVersionLease(), WithClock(clock))
+	done := make(chan error, 1)
+	go func() {
+		done <- other.AcquireWait(context.Background(), FixedBackoff{Delay: 10 * time.Second})
+	}()
+	err := advanceUntilDone(t, clock, 10*time.Second, 5*time.Minute, done, func(elapsed time.Duration) {
+		// El holder renueva: la version cambia y hay que esperar otro lease
+		if elapsed == 50*time.Second {
+			if err := holder.NewDuration(time.Minute); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Sin renovar durante un lease entero despues de la renovacion
+	if waited := clock.Now().Sub(start); waited < 110*time.Second {
+		t.Fatalf("took the lock after %v, before the renewed lease passed", waited)
+	}
+	if err := holder.Release(); !errors.Is(err, ErrNotOwner) && !errors.Is(err, ErrExpired) {
+		t.Fatalf("old holder Release: got %v, want ErrNotOwner or ErrExpired", err)
+	}
+	if d := other.RemainingDuration(); d <= 0 || d > time.Minute {
+		t.Fatalf("lease after take-over: got %v, want up to a minute", d)
+	}
+	if err := other.Release(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVersionLeaseLongerHolder(t *testing.T) {
+	svc := newFakeDynamo(t)
+	clock := locketest.NewFakeClock(time.Now())
+	holder := acquired(t, dynamoLock(t, svc, "Pepe", "Lock1", time.Hour, WithVersionLease(), WithClock(clock)))
+	other := dynamoLock(t, svc, "Pepe", "Lock1", 10*time.Second, WithVersionLease(), WithClock(clock))
+	var held *HeldError
+	if err := other.Acquire(); !errors.As(err, &held) {
+		t.Fatalf("first attempt: got %v, want *HeldError", err)
+	}
+	clock.Advance(time.Second)
+	if err := holder.Release(); err != nil {
+		t.Fatal(err)
+	}
+	// El lease es el del que espera, no el del holder que vio
+	if err := other.Acquire(); err != nil {
+		t.Fatal(err)
+	}
+	if d := other.RemainingDuration(); d <= 0 || d > 10*time.Second {
+		t.Fatalf("lease after the holder left: got %v, want up to 10s", d)
+	}
+	if err := other.Release(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Un holder de una hora que abandona el lock: se espera su lease entero
+	holder = acquired(t, dynamoLock(t, svc, "Pepe", "Lock1", time.Hour, WithVersionLease(), WithClock(clock)))
+	other = dynamoLock(t, svc, "Pepe", "Lock1", 10*time.Second, WithVersionLease(), WithClock(clock))
+	done := make(chan error, 1)
+	go func() {
+		done <- other.AcquireWait(context.Background(), FixedBackoff{Delay: time.Minute})
+	}()
+	if err := advanceUntilDone(t, clock, time.Minute, 2*time.Hour, done, nil); err != nil {
+		t.Fatal(err)
+	}
+	if d := other.RemainingDuration(); d <= 0 || d > 10*time.Second {
+		t.Fatalf("lease after taking an hour-long lock: got %v, want up to 10s", d)
+	}
+	if err := holder.Release(); !errors.Is(err, ErrNotOwner) && !errors.Is(err, ErrExpired) {
+		t.Fatalf("old holder Release: got %v, want ErrNotOwner or ErrExpired", err)
+	}
+}
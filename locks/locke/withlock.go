@@ -0,0 +1,70 @@
+package locke
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// LockOptions describe el lock que toma WithLock.
+type LockOptions struct {
+	// SvcType, Svc, Table, LockValue, LockType, Duration y Options son los
+	// argumentos de NewLock.
+	SvcType   string
+	Svc       interface{}
+	Table     string
+	LockValue string
+	LockType  string
+	Duration  time.Duration
+	Options   []Option
+	// Backoff entre intentos mientras lo tiene otro, nil para DefaultBackoff.
+	Backoff Backoff
+	// Fraction del lease entre renovaciones, como en HeartbeatOptions.
+	Fraction float64
+}
+
+// WithLock ejecuta fn con el lock descrito por opts: lo adquiere esperando
+// con AcquireWait mientras lo tenga otro, lo renueva cada fraccion de
+// Duration mientras fn trabaja y lo libera al terminar, tambien si fn hace
+// panic. fn recibe el fence para sus escrituras y un contexto que se cancela
+// en cuanto se pierde el lease; context.Cause dice por que. Devuelve juntos
+// (errors.Join) el error de fn y el de Release, o el de la renovacion si se
+// perdio el lock. La espera solo la limita ctx: el lease empieza al
+// adquirirlo.
+func WithLock(ctx context.Context, opts LockOptions, fn func(ctx context.Context, fence string) error) (err error) {
+	l, err := NewLock(opts.SvcType, opts.Svc, opts.Table, opts.LockValue, opts.LockType, opts.Duration, opts.Options...)
+	if err != nil {
+		return err
+	}
+	if err := l.AcquireWait(ctx, opts.Backoff); err != nil {
+		return err
+	}
+	// fn empieza con el lease completo y ya renovado una vez
+	if err := l.NewDurationContext(ctx, opts.Duration); err != nil {
+		rctx, rcancel := context.WithTimeout(context.WithoutCancel(ctx), releaseTimeout)
+		defer rcancel()
+		return errors.Join(err, l.ReleaseContext(rctx))
+	}
+	fctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	hb, err := StartHeartbeat(ctx, l, HeartbeatOptions{
+		Duration: opts.Duration,
+		Fraction: opts.Fraction,
+		OnLost:   cancel,
+	})
+	if err != nil {
+		return errors.Join(err, l.Release())
+	}
+	defer func() {
+		hb.Stop()
+		// Si se perdio el lease no hay nada que liberar
+		if lost := <-hb.Lost(); lost != nil {
+			err = errors.Join(err, lost)
+			return
+		}
+		rctx, rcancel := context.WithTimeout(context.WithoutCancel(ctx), releaseTimeout)
+		defer rcancel()
+		err = errors.Join(err, l.ReleaseContext(rctx))
+	}()
+	return fn(fctx, l.Fence())
+}
@@ -1,104 +1,53 @@
 package main
 
 import (
+	"context"
 	"dynamodb/locks/locke"
 	"errors"
 	"flag"
 	"log"
+	"os"
+	"strings"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 )
 
 const (
-	lockTable = "LockTable"
+	lockTable = locke.DefaultTable
 )
 
-var svc *dynamodb.DynamoDB
+var svc *dynamodb.Client
+
+type rep struct{}
+
+func (r rep) ResolveEndpoint(service string, region string) (aws.Endpoint, error) {
+	return aws.Endpoint{
+		URL: "http://localhost:8000",
+	}, nil
+}
 
 func init() {
-	sess, err := session.NewSession(&aws.Config{
-		Region:   aws.String("us-west-2"),
-		Endpoint: aws.String("http://localhost:8000")})
+	cfg, err := config.LoadDefaultConfig(context.TODO(),
+		config.WithRegion("us-west-2"),
+		func(v *config.LoadOptions) error {
+			v.EndpointResolver = rep{}
+			return nil
+		})
 	if err != nil {
 		log.Fatal(err)
 	}
-	svc = dynamodb.New(sess)
+	svc = dynamodb.NewFromConfig(cfg)
 }
 
 func CreateTable() error {
-	_, err := svc.CreateTable(
-		&dynamodb.CreateTableInput{
-			TableName:   aws.String(lockTable),
-			BillingMode: aws.String("PAY_PER_REQUEST"),
-			KeySchema: []*dynamodb.KeySchemaElement{
-				{
-					AttributeName: aws.String("tabla"),
-					KeyType:       aws.String("HASH"),
-				},
-				{
-					AttributeName: aws.String("lockvalue"),
-					KeyType:       aws.String("RANGE"),
-				},
-			},
-			AttributeDefinitions: []*dynamodb.AttributeDefinition{
-				{
-					AttributeName: aws.String("tabla"),
-					AttributeType: aws.String("S"),
-				},
-				{
-					AttributeName: aws.String("lockvalue"),
-					AttributeType: aws.String("S"),
-				},
-			},
-		})
-	if err != nil {
-		return err
-	}
-	err = svc.WaitUntilTableExists(
-		&dynamodb.DescribeTableInput{
-			TableName: aws.String(lockTable),
-		})
-	if err != nil {
-		return errors.New("error: timed out while waiting for table to become active")
-	}
-	_, err = svc.UpdateTimeToLive(
-		&dynamodb.UpdateTimeToLiveInput{
-			TableName: aws.String(lockTable),
-			TimeToLiveSpecification: &dynamodb.TimeToLiveSpecification{
-				AttributeName: aws.String("releasetime"),
-				Enabled:       aws.Bool(true),
-			},
-		},
-	)
-	if err != nil {
-		return errors.New("error: failed enable TTL")
-	}
-	_, err = svc.UpdateItem(
-		&dynamodb.UpdateItemInput{
-			TableName: aws.String(lockTable),
-			Key: map[string]*dynamodb.AttributeValue{
-				"tabla":     {S: aws.String(lockTable)},
-				"lockvalue": {S: aws.String(lockTable)},
-			},
-			ExpressionAttributeNames: map[string]*string{
-				"#fence": aws.String("fence"),
-			},
-			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
-				":zero": {N: aws.String("0")},
-			},
-			UpdateExpression: aws.String(
-				"SET #fence = :zero",
-			),
-		},
-	)
-	return err
+	return locke.EnsureTable(context.TODO(), svc, lockTable)
 }
 
 func DeleteTable() error {
-	_, err := svc.DeleteTable(
+	_, err := svc.DeleteTable(context.TODO(),
 		&dynamodb.DeleteTableInput{
 			TableName: aws.String(lockTable),
 		},
@@ -107,6 +56,15 @@ func DeleteTable() error {
 }
 
 func main() {
+	// Subcomandos de administracion; sin ellos, los flags de siempre
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		e := &cli{svc: svc, in: os.Stdin, out: os.Stdout, errOut: os.Stderr}
+		err := e.run(context.Background(), os.Args[1], os.Args[2:])
+		if err != nil && !errors.Is(err, flag.ErrHelp) {
+			log.Fatal(err)
+		}
+		return
+	}
 	ct := flag.Bool("c", false, "Create table")
 	el := flag.Bool("l", false, "Lock")
 	dt := flag.Bool("d", false, "Delete table")
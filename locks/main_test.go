@@ -1,8 +1,11 @@
 package main
 
 import (
-	"fmt"
+	"context"
+	"dynamodb/fakedynamo"
 	"dynamodb/locks/locke"
+	"dynamodb/locks/locke/locketest"
+	"fmt"
 	"testing"
 	"time"
 
@@ -62,26 +65,32 @@ var test1 []testLock = []testLock{
 	// 5 lock - tlock 5
 }
 
+// TestInitial ejecuta test1 en orden con locks "dynamo" sobre fakedynamo y un
+// reloj falso: cada paso ocurre en su segundo wait sin esperar de verdad.
 func TestInitial(t *testing.T) {
-	var locks []locke.Lock
-	var ch = make(chan struct{}, len(test1))
-	for i, test := range test1 {
-		go func(i int, l testLock) {
-			time.Sleep(l.wait * time.Second)
-			var res error
-			switch l.operation {
-			case "Lock":
-				locku, _ := locke.NewLock("dynamo", svc, "Usuarios", "Pepe", fmt.Sprintf("Lock%d",i), l.duration)
-				locks = append(locks, locku)
-				res = locku.Acquire()
-			case "Unlock":
-				res = locks[l.alock].Release()
-			}
-			assert.Equal(t, l.esperado, res == nil, fmt.Sprintf("Indice: %d Funcion: %s", i, l.operation))
-			ch <- struct{}{}
-		}(i, test)
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := locketest.NewFakeClock(start)
+	s := fakedynamo.New()
+	defer s.Close()
+	client := s.Client()
+	if err := locke.EnsureTable(context.Background(), client, lockTable); err != nil {
+		t.Fatal(err)
 	}
-	for i := 0; i < len(test1); i++ {
-		<-ch
+	locks := make([]locke.Lock, len(test1))
+	for i, l := range test1 {
+		clock.Set(start.Add(l.wait * time.Second))
+		var res error
+		switch l.operation {
+		case "Lock":
+			locku, err := locke.NewLock("dynamo", client, "Usuarios", "Pepe", fmt.Sprintf("Lock%d", i), l.duration, locke.WithClock(clock))
+			if err != nil {
+				t.Fatal(err)
+			}
+			locks[i] = locku
+			res = locku.Acquire()
+		case "Unlock":
+			res = locks[l.alock].Release()
+		}
+		assert.Equal(t, l.esperado, res == nil, fmt.Sprintf("Indice: %d Funcion: %s", i, l.operation))
 	}
 }
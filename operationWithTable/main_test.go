@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"dynamodb/fakedynamo"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOperations(t *testing.T) {
+	s := fakedynamo.New()
+	defer s.Close()
+	ctx := context.Background()
+	cfg := s.Config()
+	assert.NoError(t, CreateTable(ctx, cfg))
+	assert.NoError(t, LoadTable(ctx, cfg))
+	assert.NoError(t, CreateSecIndex(ctx, cfg))
+	assert.NoError(t, QueryTable(ctx, cfg))
+	assert.NoError(t, QueryIndex(ctx, cfg))
+	svc := dynamodb.NewFromConfig(cfg)
+	qo, err := svc.Query(ctx,
+		&dynamodb.QueryInput{
+			IndexName:                aws.String("Movies"),
+			TableName:                aws.String("Artistas"),
+			KeyConditionExpression:   aws.String("#movie = :hashKey"),
+			ExpressionAttributeNames: map[string]string{"#movie": "Movie"},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":hashKey": &types.AttributeValueMemberS{Value: "Toy Story"},
+			},
+		})
+	assert.NoError(t, err)
+	assert.Len(t, qo.Items, 2)
+	assert.NoError(t, DeleteTable(ctx, cfg))
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"dynamodb/fakedynamo"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPutItem(t *testing.T) {
+	s := fakedynamo.New()
+	defer s.Close()
+	ctx := context.Background()
+	cfg := s.Config()
+	assert.NoError(t, CreateTable(ctx, cfg))
+	for _, mv := range peliculas[0] {
+		assert.NoError(t, PutItem(ctx, cfg, artistas[0], mv))
+	}
+	svc := dynamodb.NewFromConfig(cfg)
+	qo, err := svc.Query(ctx,
+		&dynamodb.QueryInput{
+			TableName:                aws.String("JiraTable"),
+			KeyConditionExpression:   aws.String("#pk = :pk"),
+			ExpressionAttributeNames: map[string]string{"#pk": "PK"},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":pk": &types.AttributeValueMemberS{Value: artistas[0]},
+			},
+		})
+	assert.NoError(t, err)
+	// El contador (SK 0) y una pelicula por cada id
+	if assert.Len(t, qo.Items, len(peliculas[0])+1) {
+		assert.Equal(t, &types.AttributeValueMemberN{Value: "3"}, qo.Items[0]["Count"])
+		for i, mv := range peliculas[0] {
+			assert.Equal(t, &types.AttributeValueMemberS{Value: mv}, qo.Items[i+1]["Nombre"])
+		}
+	}
+	assert.NoError(t, DeleteTable(ctx, cfg))
+}